@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/guard"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger              *zap.Logger
+	Validator           token.Validator
+	Connections         connection.ConnectionStore
+	Presence            presence.Store
+	SQS                 sqsiface.SQSAPI
+	SQSURL              string
+	DeleteConnectionURL string
+
+	// Limit of connections per user, the anonymous connection counts
+	// towards it once it's authorized
+	Limit presence.Limit
+
+	// Guard replies to the client through the notify connection queue
+	Guard guard.Dependencies
+}
+
+// authorizeMessage is sent by the client over the open connection
+type authorizeMessage struct {
+	Token string `json:"token"`
+}
+
+func main() {
+	// get dynamodb table name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+
+	// get notify connection queue URL
+	queue := os.Getenv("CONFIG_SQS_NOTIFY_CONNECTION_URL")
+
+	// get delete connection queue URL
+	deleteQueue := os.Getenv("CONFIG_SQS_DELETE_CONNECTION_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create SQS client
+	sqsSvc := sqs.New(sess)
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// get the limit of connections per user
+	maxConnections, err := strconv.Atoi(os.Getenv("CONFIG_MAX_CONNECTIONS_PER_USER"))
+	if err != nil {
+		logger.Fatal("could not parse maximum of connections per user", zap.Error(err))
+	}
+
+	policy := os.Getenv("CONFIG_CONNECTION_LIMIT_POLICY")
+	if policy != presence.PolicyReject && policy != presence.PolicyEvictOldest {
+		logger.Fatal("unknown connection limit policy", zap.String("policy", policy))
+	}
+
+	// create connection store, the connections of the user are
+	// counted using the index when the limit is reached
+	connections := connection.NewDynamoDB(dynamoDbSvc, table)
+	connections.UserIdIndex = os.Getenv("CONFIG_USER_ID_INDEX_NAME")
+	connections.UserIdIndexSorted = os.Getenv("CONFIG_USER_ID_INDEX_SORTED") == "true"
+
+	// create presence store
+	presenceStore := presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID"))
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Validator: token.Validator{
+					Keys:        token.NewJWKSSource(os.Getenv("CONFIG_JWKS_URL"), keystore.CacheTTL),
+					Audience:    os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+					Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+				},
+				Connections:         connections,
+				Presence:            presenceStore,
+				SQS:                 sqsSvc,
+				SQSURL:              queue,
+				DeleteConnectionURL: deleteQueue,
+				Limit: presence.Limit{
+					Connections: connections,
+					Presence:    presenceStore,
+					Logger:      logger,
+					SQS:         sqsSvc,
+					SQSURL:      deleteQueue,
+					Max:         maxConnections,
+					Policy:      policy,
+				},
+				Guard: guard.Dependencies{
+					Logger:      logger,
+					Connections: connections,
+					SQS:         sqsSvc,
+					SQSURL:      queue,
+				},
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) apigw.WebsocketHandler {
+	return func(_ context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
+
+		// get the connection id
+		connectionId := req.RequestContext.ConnectionID
+
+		// log the attempt
+		d.Logger.Info("authorizing connection",
+			zap.String("connectionId", connectionId),
+		)
+
+		// get the token from the message
+		m := authorizeMessage{}
+		err := json.Unmarshal([]byte(req.Body), &m)
+		if err != nil || m.Token == "" {
+			d.Logger.Info("could not parse authorize message",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return guard.Reply(d.Guard, connectionId, notification.ErrorFrame("bad_request", "token is missing"), apigw.BadRequestResponse())
+		}
+
+		// verify the token
+		claims, err := d.Validator.Validate(m.Token)
+		if err != nil {
+			d.Logger.Info("invalid token",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return guard.Reply(d.Guard, connectionId, notification.ErrorFrame("unauthorized", "invalid token"), apigw.UnauthorizedResponse())
+		}
+
+		// the connection can't switch users
+		current, err := d.Connections.Get(connectionId)
+		if err != nil {
+			d.Logger.Error("could not get connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		if current.Authorized && current.UserId != claims.UserId {
+			d.Logger.Info("connection is authorized for another user",
+				zap.String("connectionId", connectionId),
+				zap.String("userId", current.UserId),
+				zap.String("tokenUserId", claims.UserId),
+			)
+			return guard.Reply(d.Guard, connectionId, notification.ErrorFrame("forbidden", "connection belongs to another user"), apigw.ForbiddenResponse())
+		}
+
+		// the anonymous connection now counts towards the limit of
+		// the user so it's uncounted by $disconnect, the connection over
+		// the limit stays anonymous
+		if current.UserId == "" {
+			admitted, err := d.Limit.Admit(claims.UserId)
+			if err != nil {
+				d.Logger.Error("could not check limit of connections",
+					zap.String("connectionId", connectionId),
+					zap.String("userId", claims.UserId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), err
+			}
+
+			if !admitted {
+				d.Logger.Info("too many connections, not authorizing",
+					zap.String("connectionId", connectionId),
+					zap.String("userId", claims.UserId),
+					zap.Int("maxConnections", d.Limit.Max),
+				)
+				return guard.Reply(d.Guard, connectionId, notification.ErrorFrame("too_many_connections", "close another connection first"), apigw.ForbiddenResponse())
+			}
+		}
+
+		// mark the connection as authorized
+		c := connection.New(connectionId, claims.UserId)
+		c.TokenId = claims.Family()
+		c.Principal = claims.UserId
+		c.Claims = claims.Map()
+		c.Scopes = claims.Scopes()
+		c.SessionExpiresAt = claims.ExpiresAt
+
+		err = d.Connections.Authorize(c)
+		if err != nil && current.UserId == "" {
+			// the connection admitted above is not counted by anything
+			// else, the gone connection was not counted by $disconnect
+			// as it was still anonymous
+			rerr := d.Presence.Release(claims.UserId)
+			if rerr != nil {
+				d.Logger.Error("could not release connection of the user",
+					zap.String("userId", claims.UserId),
+					zap.Error(rerr),
+				)
+			}
+		}
+		if errors.Is(err, connection.ErrNotFound) {
+			d.Logger.Info("connection is gone",
+				zap.String("connectionId", connectionId),
+			)
+			return apigw.OkResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not authorize connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		// the session now lasts as long as the token, the deletion scheduled
+		// for the previous expiration is ignored by cmd/delete_connection
+		err = request.DeleteConnectionAt(connectionId, c.SessionExpiresAt).DeleteDelayedSQS(d.SQS, d.DeleteConnectionURL)
+		if err != nil {
+			d.Logger.Error("could not schedule deletion of connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		d.Logger.Info("connection authorized",
+			zap.String("connectionId", connectionId),
+			zap.String("userId", claims.UserId),
+			zap.String("jti", claims.Id),
+			zap.Int64("sessionExpiresAt", c.SessionExpiresAt),
+		)
+
+		// all good
+		return guard.Reply(d.Guard, connectionId, notification.Frame{Type: "authorized"}, apigw.OkResponse())
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/internal/tokentest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/guard"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+const (
+	notifyQueue = "notify"
+	deleteQueue = "delete"
+)
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	presence    *presence.Memory
+	sqs         *awstest.SQS
+	issuer      token.Issuer
+}
+
+func newTestDependencies(t *testing.T, maxConnections int, policy string) testDependencies {
+	t.Helper()
+
+	keys := tokentest.Keys(t)
+
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		presence:    presence.NewMemory(),
+		sqs:         awstest.NewSQS(),
+		issuer:      tokentest.Issuer(keys),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:              zap.NewNop(),
+		Validator:           tokentest.Validator(keys, nil),
+		Connections:         td.connections,
+		Presence:            td.presence,
+		SQS:                 td.sqs,
+		SQSURL:              notifyQueue,
+		DeleteConnectionURL: deleteQueue,
+		Limit: presence.Limit{
+			Connections: td.connections,
+			Presence:    td.presence,
+			Logger:      zap.NewNop(),
+			SQS:         td.sqs,
+			SQSURL:      deleteQueue,
+			Max:         maxConnections,
+			Policy:      policy,
+		},
+		Guard: guard.Dependencies{
+			Logger:      zap.NewNop(),
+			Connections: td.connections,
+			SQS:         td.sqs,
+			SQSURL:      notifyQueue,
+		},
+	}
+
+	return td
+}
+
+func (td testDependencies) authorize(t *testing.T, connectionId string) int {
+	t.Helper()
+
+	tok, _, err := td.issuer.Issue("1234", []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(authorizeMessage{Token: tok})
+	res, err := handler(td.handlerDependencies)(context.Background(), &events.APIGatewayWebsocketProxyRequest{
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: connectionId,
+		},
+		Body: string(body),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode
+}
+
+// lastReply returns the last frame sent back to the connection
+func (td testDependencies) lastReply(t *testing.T) notification.Frame {
+	t.Helper()
+
+	messages := td.sqs.Messages(notifyQueue)
+	if len(messages) == 0 {
+		t.Fatal("expected reply")
+	}
+
+	n, err := notification.ConnectionFromString(aws.StringValue(messages[len(messages)-1].MessageBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := notification.Frame{}
+	if err := json.Unmarshal([]byte(n.Data), &f); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestAuthorize(t *testing.T) {
+	td := newTestDependencies(t, 2, presence.PolicyReject)
+	storetest.Open(t, td.connections, td.presence, "", "c1")
+
+	if status := td.authorize(t, "c1"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if f := td.lastReply(t); f.Type != "authorized" {
+		t.Fatalf("expected authorized reply, got %+v", f)
+	}
+
+	c, err := td.connections.Get("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Authorized || c.UserId != "1234" {
+		t.Fatalf("unexpected connection %+v", c)
+	}
+
+	// the connection counts towards the limit now, authorizing it
+	// again doesn't count it twice
+	_ = td.authorize(t, "c1")
+	if count, _ := td.presence.Connections("1234"); count != 1 {
+		t.Fatalf("expected 1 counted connection, got %d", count)
+	}
+}
+
+func TestAuthorizeLimitReject(t *testing.T) {
+	td := newTestDependencies(t, 2, presence.PolicyReject)
+	storetest.Open(t, td.connections, td.presence, "1234", "c1", "c2")
+	storetest.Open(t, td.connections, td.presence, "", "c3")
+
+	if status := td.authorize(t, "c3"); status != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, status)
+	}
+	if f := td.lastReply(t); f.Type != "error" || f.Code != "too_many_connections" {
+		t.Fatalf("expected too_many_connections error, got %+v", f)
+	}
+
+	// the connection stays anonymous and isn't counted
+	c, err := td.connections.Get("c3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Authorized || c.UserId != "" {
+		t.Fatalf("expected anonymous connection, got %+v", c)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+}
+
+func TestAuthorizeLimitEvictOldest(t *testing.T) {
+	td := newTestDependencies(t, 2, presence.PolicyEvictOldest)
+	storetest.Open(t, td.connections, td.presence, "1234", "c1", "c2")
+	storetest.Open(t, td.connections, td.presence, "", "c3")
+
+	if status := td.authorize(t, "c3"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	// the oldest connection makes room for the authorized one
+	evicted := []string{}
+	for _, m := range td.sqs.Messages(deleteQueue) {
+		r, err := request.DeleteConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Reason == request.ReasonEvicted {
+			evicted = append(evicted, r.ConnectionId)
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != "c1" {
+		t.Fatalf("expected eviction of c1, got %v", evicted)
+	}
+
+	if count, _ := td.presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+}
+
+// goneConnections loses the connection between reading and authorizing it
+type goneConnections struct {
+	*connection.Memory
+}
+
+func (g goneConnections) Authorize(c connection.Connection) error {
+	_ = g.Memory.Delete(c.ConnectionId)
+	return connection.ErrNotFound
+}
+
+func TestAuthorizeGone(t *testing.T) {
+	td := newTestDependencies(t, 2, presence.PolicyReject)
+	td.Connections = goneConnections{td.connections}
+	storetest.Open(t, td.connections, td.presence, "", "c1")
+
+	if status := td.authorize(t, "c1"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	// $disconnect saw the anonymous connection, the admitted one is released
+	if count, _ := td.presence.Connections("1234"); count != 0 {
+		t.Fatalf("expected no counted connections, got %d", count)
+	}
+}
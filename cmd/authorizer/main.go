@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/cookie"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/ticket"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+// authentication methods which can be enabled per stage
+const (
+	methodToken     = "token"
+	methodTicket    = "ticket"
+	methodCookie    = "cookie"
+	methodAnonymous = "anonymous"
+)
+
+var errNoCredentials = errors.New("no credentials provided")
+
+type handlerDependencies struct {
+	Logger        *zap.Logger
+	Validator     token.Validator
+	Tickets       ticket.Store
+	SessionSecret []byte
+	SessionCookie string
+
+	// defaults used when the stage doesn't override them with
+	// the authMethods and allowedOrigins stage variables
+	Methods        string
+	AllowedOrigins string
+
+	// AllowQueryToken accepts the token in the query string for the old
+	// clients, the query string ends up in the access logs
+	AllowQueryToken bool
+}
+
+// identity is the verified user of the connection
+type identity struct {
+	UserId    string
+	TokenId   string
+	Scopes    []string
+	ExpiresAt int64
+	Method    string
+}
+
+func main() {
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// load the public keys published by the issuer, the keys are cached
+	// and reloaded early only when a token signed by an unknown key arrives
+	keys := token.NewJWKSSource(os.Getenv("CONFIG_JWKS_URL"), keystore.CacheTTL)
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Validator: token.Validator{
+					Keys:        keys,
+					Audience:    os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+					Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+				},
+				Tickets:        ticket.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_TICKETS_TABLE_ID")),
+				SessionSecret:  []byte(os.Getenv("CONFIG_SESSION_SECRET")),
+				SessionCookie:  os.Getenv("CONFIG_SESSION_COOKIE"),
+				Methods:        os.Getenv("CONFIG_AUTH_METHODS"),
+				AllowedOrigins: os.Getenv("CONFIG_ALLOWED_ORIGINS"),
+
+				AllowQueryToken: os.Getenv("CONFIG_ALLOW_QUERY_TOKEN") == "true",
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(_ context.Context, req *apigw.APIGatewayV2CustomAuthorizerRequest) (apigw.APIGatewayV2CustomAuthorizerResponse, error) {
+	return func(_ context.Context, req *apigw.APIGatewayV2CustomAuthorizerRequest) (apigw.APIGatewayV2CustomAuthorizerResponse, error) {
+
+		// get the configuration of the stage
+		methods := list(stageValue(req, "authMethods", d.Methods))
+		origins := list(stageValue(req, "allowedOrigins", d.AllowedOrigins))
+
+		// browsers always send the origin, so foreign sites can't open
+		// the connection, native apps usually don't send it at all, the
+		// empty list lets in no browser at all
+		origin := apigw.Header(req.Headers, "origin")
+		if origin != "" && !contains(origins, origin) {
+			d.Logger.Info("origin not allowed",
+				zap.String("methodArn", req.MethodARN),
+				zap.String("origin", origin),
+			)
+			return apigw.AuthorizerDeny(req.MethodARN), nil
+		}
+
+		i, err := identify(d, req, methods, origin != "" && contains(origins, origin))
+		if errors.Is(err, errNoCredentials) && contains(methods, methodAnonymous) {
+			// the connection has to be authorized later by the
+			// authorize action, invalid credentials are still denied
+			d.Logger.Info("anonymous connection allowed",
+				zap.String("methodArn", req.MethodARN),
+			)
+			return apigw.AuthorizerAllow(req.MethodARN, "anonymous"), nil
+		}
+		if err != nil {
+			d.Logger.Info("connection denied",
+				zap.String("methodArn", req.MethodARN),
+				zap.Error(err),
+			)
+			return apigw.AuthorizerDeny(req.MethodARN), nil
+		}
+
+		d.Logger.Info("connection authorized",
+			zap.String("userId", i.UserId),
+			zap.String("tokenId", i.TokenId),
+			zap.String("method", i.Method),
+		)
+
+		// pass the user, the token, the scopes and the expiration to
+		// the connect handler, the scopes are checked by the route
+		// handlers as the policy is evaluated only once for the whole
+		// connection, the connection is closed once the credentials
+		// expire
+		res := apigw.AuthorizerAllow(req.MethodARN, i.UserId)
+		res.Context[apigw.AuthorizerUserIdKey] = i.UserId
+		res.Context[apigw.AuthorizerTokenIdKey] = i.TokenId
+		res.Context[apigw.AuthorizerScopesKey] = token.JoinScopes(i.Scopes)
+		res.Context[apigw.AuthorizerExpiresKey] = i.ExpiresAt
+
+		// all good
+		return res, nil
+	}
+}
+
+// identify verifies the first credentials found in the request using one
+// of the enabled methods, browsers can't set headers on websocket upgrade,
+// so they should either pass the token as a subprotocol, exchange it for
+// a single-use ticket and put it in the query string or rely on the session
+// cookie
+func identify(d handlerDependencies, req *apigw.APIGatewayV2CustomAuthorizerRequest, methods []string, trustedOrigin bool) (identity, error) {
+	if id := req.QueryStringParameters["ticket"]; id != "" && contains(methods, methodTicket) {
+		t, err := d.Tickets.Consume(id)
+		if err != nil {
+			return identity{}, err
+		}
+
+		// the token or the user might have been revoked after the ticket
+		// was issued
+		err = d.Validator.CheckRevoked(t.TokenId)
+		if err != nil {
+			return identity{}, err
+		}
+
+		err = d.Validator.CheckUserRevoked(t.UserId, t.AuthTime)
+		if err != nil {
+			return identity{}, err
+		}
+
+		return identity{
+			UserId:    t.UserId,
+			TokenId:   t.TokenId,
+			Scopes:    t.Scopes,
+			ExpiresAt: t.SessionExpiresAt,
+			Method:    methodTicket,
+		}, nil
+	}
+
+	if contains(methods, methodToken) {
+		t := apigw.BearerToken(req.Headers)
+		if t == "" {
+			t = apigw.ProtocolToken(req.Headers)
+		}
+		if t == "" && d.AllowQueryToken {
+			t = req.QueryStringParameters["token"]
+		}
+
+		if t != "" {
+			claims, err := d.Validator.Validate(t)
+			if err != nil {
+				return identity{}, err
+			}
+
+			return identity{
+				UserId:    claims.UserId,
+				TokenId:   claims.Family(),
+				Scopes:    claims.Scopes(),
+				ExpiresAt: claims.ExpiresAt,
+				Method:    methodToken,
+			}, nil
+		}
+	}
+
+	// browsers send the cookie with every connection, so it's accepted
+	// only from the explicitly allowed origins
+	if value, ok := cookie.Find(d.SessionCookie, req.Cookies, req.Headers); ok && contains(methods, methodCookie) {
+		if !trustedOrigin {
+			return identity{}, errors.New("session cookie sent from untrusted origin")
+		}
+
+		if len(d.SessionSecret) < 32 {
+			return identity{}, errors.New("session secret is not configured")
+		}
+
+		s, err := cookie.Verify(value, d.SessionSecret)
+		if err != nil {
+			return identity{}, err
+		}
+
+		err = d.Validator.CheckUserRevoked(s.UserId, s.IssuedAt)
+		if err != nil {
+			return identity{}, err
+		}
+
+		return identity{
+			UserId:    s.UserId,
+			Scopes:    token.SplitScopes(s.Scope),
+			ExpiresAt: s.ExpiresAt,
+			Method:    methodCookie,
+		}, nil
+	}
+
+	return identity{}, errNoCredentials
+}
+
+// stageValue returns the stage variable if it's set or the given default
+func stageValue(req *apigw.APIGatewayV2CustomAuthorizerRequest, name string, value string) string {
+	if v, ok := req.StageVariables[name]; ok {
+		return v
+	}
+
+	return value
+}
+
+// list splits comma separated configuration value
+func list(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pipetail/sst-websocket/internal/tokentest"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/ticket"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+const methodArn = "arn:aws:execute-api:eu-west-1:123456789012:abcdef/prod/$connect"
+
+type testDependencies struct {
+	handlerDependencies
+	tickets     *ticket.Memory
+	revocations *revocation.Memory
+	issuer      token.Issuer
+}
+
+func newTestDependencies(t *testing.T, methods string) testDependencies {
+	t.Helper()
+
+	keys := tokentest.Keys(t)
+
+	td := testDependencies{
+		tickets:     ticket.NewMemory(),
+		revocations: revocation.NewMemory(),
+		issuer:      tokentest.Issuer(keys),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:    zap.NewNop(),
+		Validator: tokentest.Validator(keys, td.revocations),
+		Tickets:   td.tickets,
+		Methods:   methods,
+	}
+
+	return td
+}
+
+func (td testDependencies) issue(t *testing.T) (string, token.Claims) {
+	t.Helper()
+
+	tok, claims, err := td.issuer.Issue("1234", []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tok, claims
+}
+
+func authorize(t *testing.T, d handlerDependencies, req *apigw.APIGatewayV2CustomAuthorizerRequest) (string, string) {
+	t.Helper()
+
+	req.MethodARN = methodArn
+	res, err := handler(d)(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userId, _ := res.Context[apigw.AuthorizerUserIdKey].(string)
+	return res.PolicyDocument.Statement[0].Effect, userId
+}
+
+func TestAuthorizerToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		queryToken bool
+		request    func(tok string) *apigw.APIGatewayV2CustomAuthorizerRequest
+		effect     string
+	}{
+		{
+			name: "authorization header",
+			request: func(tok string) *apigw.APIGatewayV2CustomAuthorizerRequest {
+				return &apigw.APIGatewayV2CustomAuthorizerRequest{
+					Headers: map[string]string{"Authorization": "Bearer " + tok},
+				}
+			},
+			effect: "Allow",
+		},
+		{
+			name: "subprotocol",
+			request: func(tok string) *apigw.APIGatewayV2CustomAuthorizerRequest {
+				return &apigw.APIGatewayV2CustomAuthorizerRequest{
+					Headers: map[string]string{"Sec-WebSocket-Protocol": "chat, " + apigw.ProtocolTokenPrefix + tok},
+				}
+			},
+			effect: "Allow",
+		},
+		{
+			name: "query string is ignored by default",
+			request: func(tok string) *apigw.APIGatewayV2CustomAuthorizerRequest {
+				return &apigw.APIGatewayV2CustomAuthorizerRequest{
+					QueryStringParameters: map[string]string{"token": tok},
+				}
+			},
+			effect: "Deny",
+		},
+		{
+			name:       "query string of the old clients",
+			queryToken: true,
+			request: func(tok string) *apigw.APIGatewayV2CustomAuthorizerRequest {
+				return &apigw.APIGatewayV2CustomAuthorizerRequest{
+					QueryStringParameters: map[string]string{"token": tok},
+				}
+			},
+			effect: "Allow",
+		},
+		{
+			name: "invalid token",
+			request: func(tok string) *apigw.APIGatewayV2CustomAuthorizerRequest {
+				return &apigw.APIGatewayV2CustomAuthorizerRequest{
+					Headers: map[string]string{"Authorization": "Bearer " + tok + "x"},
+				}
+			},
+			effect: "Deny",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDependencies(t, "token")
+			td.AllowQueryToken = tt.queryToken
+			tok, _ := td.issue(t)
+
+			effect, userId := authorize(t, td.handlerDependencies, tt.request(tok))
+			if effect != tt.effect {
+				t.Fatalf("expected %s, got %s", tt.effect, effect)
+			}
+			if effect == "Allow" && userId != "1234" {
+				t.Fatalf("expected user 1234, got %q", userId)
+			}
+		})
+	}
+}
+
+func TestAuthorizerTicket(t *testing.T) {
+	td := newTestDependencies(t, "ticket")
+	_, claims := td.issue(t)
+
+	tk, err := ticket.New(claims.UserId, claims.Family(), claims.Scopes(), claims.ExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.AuthTime = claims.AuthenticatedAt()
+	if err := td.tickets.Put(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	req := func() *apigw.APIGatewayV2CustomAuthorizerRequest {
+		return &apigw.APIGatewayV2CustomAuthorizerRequest{
+			QueryStringParameters: map[string]string{"ticket": tk.TicketId},
+		}
+	}
+
+	if effect, userId := authorize(t, td.handlerDependencies, req()); effect != "Allow" || userId != "1234" {
+		t.Fatalf("expected user 1234 allowed, got %s %q", effect, userId)
+	}
+
+	// the ticket can't be replayed
+	if effect, _ := authorize(t, td.handlerDependencies, req()); effect != "Deny" {
+		t.Fatalf("expected replayed ticket denied, got %s", effect)
+	}
+}
+
+func TestAuthorizerTicketRevoked(t *testing.T) {
+	td := newTestDependencies(t, "ticket")
+	_, claims := td.issue(t)
+
+	tk, err := ticket.New(claims.UserId, claims.Family(), claims.Scopes(), claims.ExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := td.tickets.Put(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the token was revoked after the ticket was issued
+	if err := td.revocations.Revoke(claims.Family(), claims.ExpiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	effect, _ := authorize(t, td.handlerDependencies, &apigw.APIGatewayV2CustomAuthorizerRequest{
+		QueryStringParameters: map[string]string{"ticket": tk.TicketId},
+	})
+	if effect != "Deny" {
+		t.Fatalf("expected ticket of revoked token denied, got %s", effect)
+	}
+}
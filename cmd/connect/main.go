@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 
@@ -11,18 +14,32 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
 	connection "github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
 	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/token"
 	"go.uber.org/zap"
 )
 
+// labelPrefix marks the query string parameters and the authorizer
+// context values stored as labels of the connection
+const labelPrefix = "label."
+
 type handlerDependencies struct {
-	DynamoDB  *dynamodb.DynamoDB
-	Logger    *zap.Logger
-	TableName string
-	SQS       *sqs.SQS
-	SQSURL    string
+	Connections connection.ConnectionStore
+	Presence    presence.Store
+	Logger      *zap.Logger
+	SQS         sqsiface.SQSAPI
+	SQSURL      string
+
+	// AnonymousSession is how long the anonymous connection can stay
+	// open, it's extended by the authorize action
+	AnonymousSession time.Duration
+
+	// Limit of connections per user
+	Limit presence.Limit
 }
 
 func main() {
@@ -46,15 +63,51 @@ func main() {
 	// create a logger
 	logger, _ := zap.NewProduction()
 
+	// get session length of anonymous connections in seconds
+	anonymousSession, err := strconv.Atoi(os.Getenv("CONFIG_ANONYMOUS_SESSION_TTL"))
+	if err != nil {
+		logger.Fatal("could not parse anonymous session TTL", zap.Error(err))
+	}
+
+	// get the limit of connections per user
+	maxConnections, err := strconv.Atoi(os.Getenv("CONFIG_MAX_CONNECTIONS_PER_USER"))
+	if err != nil {
+		logger.Fatal("could not parse maximum of connections per user", zap.Error(err))
+	}
+
+	policy := os.Getenv("CONFIG_CONNECTION_LIMIT_POLICY")
+	if policy != presence.PolicyReject && policy != presence.PolicyEvictOldest {
+		logger.Fatal("unknown connection limit policy", zap.String("policy", policy))
+	}
+
+	// create connection store, the connections of the user are
+	// counted using the index when the limit is reached
+	connections := connection.NewDynamoDB(dynamoDbSvc, table)
+	connections.UserIdIndex = os.Getenv("CONFIG_USER_ID_INDEX_NAME")
+	connections.UserIdIndexSorted = os.Getenv("CONFIG_USER_ID_INDEX_SORTED") == "true"
+
+	// create presence store
+	presenceStore := presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID"))
+
 	// start the main handler
 	lambda.Start(
 		handler(
 			handlerDependencies{
-				Logger:    logger,
-				DynamoDB:  dynamoDbSvc,
-				TableName: table,
-				SQS:       sqsSvc,
-				SQSURL:    queue,
+				Logger:           logger,
+				Connections:      connections,
+				Presence:         presenceStore,
+				SQS:              sqsSvc,
+				SQSURL:           queue,
+				AnonymousSession: time.Duration(anonymousSession) * time.Second,
+				Limit: presence.Limit{
+					Connections: connections,
+					Presence:    presenceStore,
+					Logger:      logger,
+					SQS:         sqsSvc,
+					SQSURL:      queue,
+					Max:         maxConnections,
+					Policy:      policy,
+				},
 			},
 		),
 	)
@@ -71,26 +124,117 @@ func handler(d handlerDependencies) func(_ context.Context, req *events.APIGatew
 			zap.String("connectionId", connectionId),
 		)
 
-		// extract userId from the querystring
-		userId, ok := req.QueryStringParameters["userId"]
-		if !ok {
-			d.Logger.Error("missing userId query string parameter",
-				zap.String("connectionId", connectionId),
-			)
-			return apigw.BadRequestResponse(), nil
+		// get userId verified by the authorizer, connections without it
+		// were let in anonymously and have to use the authorize action
+		c := connection.New(connectionId, "")
+		c.SessionExpiresAt = time.Now().Add(d.AnonymousSession).Unix()
+		if userId, ok := apigw.AuthorizerValue(req, apigw.AuthorizerUserIdKey); ok && userId != "" {
+			c.UserId = userId
+			c.Authorized = true
+			c.Principal = userId
+			c.Claims = apigw.AuthorizerValues(req)
+
+			scopes, _ := apigw.AuthorizerValue(req, apigw.AuthorizerScopesKey)
+			c.Scopes = token.SplitScopes(scopes)
+
+			// remember the token so the connection can be closed
+			// when the token is revoked
+			c.TokenId, _ = apigw.AuthorizerValue(req, apigw.AuthorizerTokenIdKey)
+
+			// the session lasts as long as the credentials are valid
+			if expiresAt, ok := apigw.AuthorizerInt64(req, apigw.AuthorizerExpiresKey); ok && expiresAt > 0 {
+				c.SessionExpiresAt = expiresAt
+			}
+		}
+
+		// remember the client of the connection
+		describe(&c, req)
+
+		d.Logger.Info("connection identified",
+			zap.String("connectionId", connectionId),
+			zap.String("userId", c.UserId),
+			zap.Bool("authorized", c.Authorized),
+			zap.Int64("sessionExpiresAt", c.SessionExpiresAt),
+			zap.String("deviceId", c.DeviceId),
+			zap.String("appVersion", c.AppVersion),
+		)
+
+		// the refused connection never gets $disconnect, so the counter
+		// and the record are cleaned up unless the connection is accepted
+		acquired, created, accepted := false, false, false
+		defer func() {
+			if accepted {
+				return
+			}
+
+			if created {
+				err := d.Connections.Delete(connectionId)
+				if err != nil {
+					d.Logger.Error("could not delete record of refused connection",
+						zap.String("connectionId", connectionId),
+						zap.Error(err),
+					)
+				}
+			}
+
+			if acquired {
+				err := d.Presence.Release(c.UserId)
+				if err != nil {
+					d.Logger.Error("could not release refused connection",
+						zap.String("userId", c.UserId),
+						zap.Error(err),
+					)
+				}
+			}
+		}()
+
+		// count the connection of the user, the anonymous connections
+		// are counted once they are authorized
+		if c.UserId != "" {
+			admitted, err := d.Limit.Admit(c.UserId)
+			if err != nil {
+				d.Logger.Error("could not check limit of connections",
+					zap.String("userId", c.UserId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), fmt.Errorf("could not check limit of connections: %s", err)
+			}
+
+			if !admitted {
+				d.Logger.Info("too many connections, rejecting",
+					zap.String("connectionId", connectionId),
+					zap.String("userId", c.UserId),
+					zap.Int("maxConnections", d.Limit.Max),
+				)
+				return apigw.ForbiddenResponse(), nil
+			}
+			acquired = true
 		}
 
 		// put record to db
-		err := connection.New(connectionId, userId).Create(d.DynamoDB, d.TableName)
+		err := d.Connections.Create(c)
 		if err != nil {
 			d.Logger.Error("could not create a dynamodb record",
 				zap.Error(err),
 			)
 			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not create DynamoDB record: %s", err)
 		}
+		created = true
+
+		// the user is online now
+		if c.UserId != "" {
+			err = d.Presence.Touch(c.UserId, time.Now().Unix())
+			if err != nil {
+				d.Logger.Error("could not update presence",
+					zap.String("userId", c.UserId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), fmt.Errorf("could not update presence: %s", err)
+			}
+		}
 
-		// send delayed deletion request
-		err = request.DeleteConnectionFromId(connectionId).DeleteDelayedSQS(d.SQS, d.SQSURL)
+		// close the connection when the session expires
+		err = request.DeleteConnectionAt(connectionId, c.SessionExpiresAt).DeleteDelayedSQS(d.SQS, d.SQSURL)
 		if err != nil {
 			d.Logger.Error("could not schedule deletion of connection",
 				zap.Error(err),
@@ -98,7 +242,65 @@ func handler(d handlerDependencies) func(_ context.Context, req *events.APIGatew
 			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not schedule deletion of connection: %s", err)
 		}
 
+		// the negotiated subprotocol has to be sent back,
+		// otherwise browsers close the connection
+		res := apigw.OkResponse()
+		if c.Subprotocol != "" {
+			res.Headers = map[string]string{
+				"Sec-WebSocket-Protocol": c.Subprotocol,
+			}
+		}
+
 		// all good
-		return apigw.OkResponse(), nil
+		accepted = true
+		return res, nil
+	}
+}
+
+// describe fills the metadata of the connection from the request, the
+// browsers can't set custom headers so the device and the app version
+// are accepted from the query string as well
+func describe(c *connection.Connection, req *events.APIGatewayWebsocketProxyRequest) {
+	c.SourceIp = req.RequestContext.Identity.SourceIP
+	c.UserAgent = apigw.Header(req.Headers, "user-agent")
+	if c.UserAgent == "" {
+		c.UserAgent = req.RequestContext.Identity.UserAgent
+	}
+	c.DeviceId = firstOf(apigw.Header(req.Headers, "x-device-id"), req.QueryStringParameters["deviceId"])
+	c.AppVersion = firstOf(apigw.Header(req.Headers, "x-app-version"), req.QueryStringParameters["appVersion"])
+	c.DomainName = req.RequestContext.DomainName
+	c.Stage = req.RequestContext.Stage
+
+	// accept the first of the offered subprotocols
+	if offered := apigw.Subprotocols(req.Headers); len(offered) > 0 {
+		c.Subprotocol = offered[0]
 	}
+
+	// labels are passed as label.<name> either in the query string
+	// or in the authorizer context, the authorizer wins
+	labels := map[string]string{}
+	for k, v := range req.QueryStringParameters {
+		if strings.HasPrefix(k, labelPrefix) && len(k) > len(labelPrefix) {
+			labels[strings.TrimPrefix(k, labelPrefix)] = v
+		}
+	}
+	for k, v := range apigw.AuthorizerValues(req) {
+		if strings.HasPrefix(k, labelPrefix) && len(k) > len(labelPrefix) {
+			labels[strings.TrimPrefix(k, labelPrefix)] = v
+		}
+	}
+	if len(labels) > 0 {
+		c.Labels = labels
+	}
+}
+
+// firstOf returns the first non-empty value
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+const deleteQueue = "delete"
+
+// deleteRequests returns the immediate deletion requests sent to the queue
+func deleteRequests(t *testing.T, s *awstest.SQS) []request.DeleteConnection {
+	t.Helper()
+
+	deletions := []request.DeleteConnection{}
+	for _, m := range s.Messages(deleteQueue) {
+		if aws.Int64Value(m.DelaySeconds) > 0 {
+			continue
+		}
+
+		r, err := request.DeleteConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deletions = append(deletions, r)
+	}
+
+	return deletions
+}
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	presence    *presence.Memory
+	sqs         *awstest.SQS
+}
+
+func newTestDependencies(maxConnections int, policy string) testDependencies {
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		presence:    presence.NewMemory(),
+		sqs:         awstest.NewSQS(),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Connections:      td.connections,
+		Presence:         td.presence,
+		Logger:           zap.NewNop(),
+		SQS:              td.sqs,
+		SQSURL:           deleteQueue,
+		AnonymousSession: time.Minute,
+		Limit: presence.Limit{
+			Connections: td.connections,
+			Presence:    td.presence,
+			Logger:      zap.NewNop(),
+			SQS:         td.sqs,
+			SQSURL:      deleteQueue,
+			Max:         maxConnections,
+			Policy:      policy,
+		},
+	}
+
+	return td
+}
+
+func connectRequest(connectionId string, userId string) *events.APIGatewayWebsocketProxyRequest {
+	req := &events.APIGatewayWebsocketProxyRequest{
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: connectionId,
+		},
+	}
+
+	if userId != "" {
+		req.RequestContext.Authorizer = map[string]interface{}{
+			apigw.AuthorizerUserIdKey:  userId,
+			apigw.AuthorizerTokenIdKey: "fam",
+			apigw.AuthorizerScopesKey:  "ping",
+			apigw.AuthorizerExpiresKey: time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	return req
+}
+
+func connect(t *testing.T, d handlerDependencies, connectionId string, userId string) int {
+	t.Helper()
+
+	res, err := handler(d)(context.Background(), connectRequest(connectionId, userId))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode
+}
+
+func TestConnect(t *testing.T) {
+	td := newTestDependencies(0, presence.PolicyReject)
+
+	if status := connect(t, td.handlerDependencies, "c1", "1234"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	c, err := td.connections.Get("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UserId != "1234" || !c.Authorized || c.TokenId != "fam" || len(c.Scopes) != 1 {
+		t.Fatalf("unexpected connection %+v", c)
+	}
+
+	if count, _ := td.presence.Connections("1234"); count != 1 {
+		t.Fatalf("expected 1 counted connection, got %d", count)
+	}
+
+	// the deletion is scheduled for the end of the session
+	messages := td.sqs.Messages(deleteQueue)
+	if len(messages) != 1 || aws.Int64Value(messages[0].DelaySeconds) == 0 {
+		t.Fatalf("expected scheduled deletion, got %v", messages)
+	}
+}
+
+func TestConnectAnonymous(t *testing.T) {
+	td := newTestDependencies(1, presence.PolicyReject)
+
+	// the anonymous connections are not counted
+	for _, id := range []string{"c1", "c2"} {
+		if status := connect(t, td.handlerDependencies, id, ""); status != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+	}
+
+	c, err := td.connections.Get("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Authorized || c.UserId != "" {
+		t.Fatalf("unexpected connection %+v", c)
+	}
+}
+
+func TestConnectLimitReject(t *testing.T) {
+	td := newTestDependencies(2, presence.PolicyReject)
+
+	for _, id := range []string{"c1", "c2"} {
+		if status := connect(t, td.handlerDependencies, id, "1234"); status != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+	}
+
+	if status := connect(t, td.handlerDependencies, "c3", "1234"); status != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, status)
+	}
+
+	// the refused connection leaves no record and isn't counted
+	if _, err := td.connections.Find("c3"); err != connection.ErrNotFound {
+		t.Fatalf("expected no record of refused connection, got %v", err)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+
+	// the other users are not affected
+	if status := connect(t, td.handlerDependencies, "c4", "5678"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+}
+
+func TestConnectLimitFixesCounter(t *testing.T) {
+	td := newTestDependencies(2, presence.PolicyReject)
+
+	if status := connect(t, td.handlerDependencies, "c1", "1234"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	// a connection which expired without $disconnect is still counted
+	_, _ = td.presence.Acquire("1234", 0)
+
+	if status := connect(t, td.handlerDependencies, "c2", "1234"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+}
+
+func TestConnectLimitEvictOldest(t *testing.T) {
+	td := newTestDependencies(2, presence.PolicyEvictOldest)
+
+	for _, id := range []string{"c1", "c2", "c3"} {
+		if status := connect(t, td.handlerDependencies, id, "1234"); status != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+	}
+
+	// the oldest connection is evicted and closed through the queue
+	deletions := deleteRequests(t, td.sqs)
+	if len(deletions) != 1 || deletions[0].ConnectionId != "c1" || deletions[0].Reason != request.ReasonEvicted {
+		t.Fatalf("expected eviction of c1, got %+v", deletions)
+	}
+
+	c, err := td.connections.Find("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Evicted || c.Counted() {
+		t.Fatalf("expected evicted connection, got %+v", c)
+	}
+
+	if count, _ := td.presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+
+	// the next connect evicts the next oldest one, not the evicted one
+	if status := connect(t, td.handlerDependencies, "c4", "1234"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	deletions = deleteRequests(t, td.sqs)
+	if len(deletions) != 2 || deletions[1].ConnectionId != "c2" {
+		t.Fatalf("expected eviction of c2, got %+v", deletions)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+}
+
+func TestConnectSubprotocol(t *testing.T) {
+	td := newTestDependencies(0, presence.PolicyReject)
+
+	// the token passed as a subprotocol is never echoed back
+	req := connectRequest("c1", "1234")
+	req.Headers = map[string]string{
+		"Sec-WebSocket-Protocol": apigw.ProtocolTokenPrefix + "eyJ.eyJ.sig, chat",
+	}
+
+	res, err := handler(td.handlerDependencies)(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol := res.Headers["Sec-WebSocket-Protocol"]; protocol != "chat" {
+		t.Fatalf("expected subprotocol chat, got %q", protocol)
+	}
+}
+
+func TestConnectMetadata(t *testing.T) {
+	td := newTestDependencies(0, presence.PolicyReject)
+
+	req := connectRequest("c1", "1234")
+	req.RequestContext.Authorizer.(map[string]interface{})["label.plan"] = "pro"
+	req.RequestContext.Identity.SourceIP = "10.0.0.1"
+	req.RequestContext.Identity.UserAgent = "identity"
+	req.RequestContext.DomainName = "ws.example.com"
+	req.RequestContext.Stage = "prod"
+	req.Headers = map[string]string{
+		"User-Agent":  "client/1.0",
+		"X-Device-Id": "phone",
+	}
+	req.QueryStringParameters = map[string]string{
+		"deviceId":   "ignored",
+		"appVersion": "1.2.3",
+		"label.plan": "free",
+		"label.team": "core",
+	}
+
+	if _, err := handler(td.handlerDependencies)(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := td.connections.Get("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the headers win over the query string, the authorizer wins
+	// over the query string labels
+	if c.SourceIp != "10.0.0.1" || c.UserAgent != "client/1.0" || c.DeviceId != "phone" || c.AppVersion != "1.2.3" {
+		t.Fatalf("unexpected client metadata %+v", c)
+	}
+	if c.DomainName != "ws.example.com" || c.Stage != "prod" {
+		t.Fatalf("unexpected API metadata %+v", c)
+	}
+	if c.Labels["plan"] != "pro" || c.Labels["team"] != "core" {
+		t.Fatalf("unexpected labels %v", c.Labels)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const usage = `usage: migrate -table name
+
+rewrites Created of the connection records stored as RFC3339 string
+into epoch time so the records get into the user index sorted by Created
+`
+
+func main() {
+	table := flag.String("table", "", "name of the DynamoDB connections table")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	if *table == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	migrated, err := run(dynamodb.New(sess), *table)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d records migrated\n", migrated)
+}
+
+// run converts the string Created of all records in the table, the
+// records changed in the meantime are left alone
+func run(svc *dynamodb.DynamoDB, table string) (int, error) {
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#c": aws.String("Created"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {
+				S: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+		},
+		FilterExpression:     aws.String("attribute_type(#c, :s)"),
+		ProjectionExpression: aws.String("ConnectionId, #c"),
+		TableName:            aws.String(table),
+	}
+
+	// go through the items page by page, the first error
+	// stops the migration
+	migrated := 0
+	var updateErr error
+	err := svc.ScanPages(input, func(res *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range res.Items {
+			created, err := time.Parse(time.RFC3339, aws.StringValue(item["Created"].S))
+			if err != nil {
+				updateErr = fmt.Errorf("could not parse created of %s: %s", aws.StringValue(item["ConnectionId"].S), err)
+				return false
+			}
+
+			_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+				Key: map[string]*dynamodb.AttributeValue{
+					"ConnectionId": item["ConnectionId"],
+				},
+				ConditionExpression: aws.String("attribute_type(#c, :s)"),
+				UpdateExpression:    aws.String("SET #c = :n"),
+				ExpressionAttributeNames: map[string]*string{
+					"#c": aws.String("Created"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":s": {
+						S: aws.String(dynamodb.ScalarAttributeTypeS),
+					},
+					":n": {
+						N: aws.String(strconv.FormatInt(created.Unix(), 10)),
+					},
+				},
+				TableName: aws.String(table),
+			})
+
+			// the record was deleted or rewritten meanwhile
+			var aerr awserr.Error
+			if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				continue
+			}
+			if err != nil {
+				updateErr = fmt.Errorf("could not migrate %s: %s", aws.StringValue(item["ConnectionId"].S), err)
+				return false
+			}
+
+			migrated++
+		}
+
+		return true
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("could not scan connections: %s", err)
+	}
+
+	return migrated, updateErr
+}
@@ -6,47 +6,74 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/sqs"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/guard"
 	"github.com/pipetail/sst-websocket/pkg/notification"
 	"go.uber.org/zap"
 )
 
+// requiredScopes have to be granted to the connection to use this route,
+// the fallback route is open to any authorized connection
+var requiredScopes = []string{}
+
 type handlerDependencies struct {
-	Logger *zap.Logger
-	SQS    *sqs.SQS
-	SQSURL string
+	Logger      *zap.Logger
+	Connections connection.ConnectionStore
+	SQS         *sqs.SQS
+	SQSURL      string
 }
 
 func main() {
 	queue := os.Getenv("CONFIG_SQS_NOTIFY_CONNECTION_URL")
 
+	// get dynamodb table name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+
 	// create AWS session
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
 
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
 	// create SQS client
 	sqsSvc := sqs.New(sess)
 
 	// create a logger
 	logger, _ := zap.NewProduction()
 
-	// start the main handler
+	d := handlerDependencies{
+		Logger:      logger,
+		Connections: connection.NewDynamoDB(dynamoDbSvc, table),
+		SQS:         sqsSvc,
+		SQSURL:      queue,
+	}
+
+	g := guard.Dependencies{
+		Logger:      d.Logger,
+		Connections: d.Connections,
+		SQS:         d.SQS,
+		SQSURL:      d.SQSURL,
+	}
+
+	// start the main handler, only messages from authorized
+	// connections with the required scopes get through the guard
 	lambda.Start(
-		handler(
-			handlerDependencies{
-				Logger: logger,
-				SQS:    sqsSvc,
-				SQSURL: queue,
-			},
+		guard.Authorized(g,
+			guard.RequireScopes(g, requiredScopes,
+				handler(d),
+			),
 		),
 	)
 }
 
-func handler(d handlerDependencies) func(_ context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
+func handler(d handlerDependencies) apigw.WebsocketHandler {
 	return func(_ context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
 
 		// get and validate the parameters
@@ -2,23 +2,36 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
 	"github.com/pipetail/sst-websocket/pkg/request"
 	"go.uber.org/zap"
 )
 
 type handlerDependencies struct {
 	Logger             *zap.Logger
-	ApiGateway         *apigatewaymanagementapi.ApiGatewayManagementApi
+	ApiGateway         apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
 	ApiGatewayEndpoint string
+	Connections        connection.ConnectionStore
+	Presence           presence.Store
+	SQS                sqsiface.SQSAPI
+	SQSURL             string
 }
 
 func main() {
@@ -31,6 +44,21 @@ func main() {
 	})
 	apiGatewaySvc := apigatewaymanagementapi.New(apiGatewaySess)
 
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// get dynamodb table name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+
+	// get delete connection queue URL, the requests scheduled too
+	// far in the future are sent back to the same queue
+	queue := os.Getenv("CONFIG_SQS_DELETE_CONNECTION_URL")
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
 	// create a logger
 	logger, _ := zap.NewProduction()
 
@@ -40,56 +68,161 @@ func main() {
 			Logger:             logger,
 			ApiGateway:         apiGatewaySvc,
 			ApiGatewayEndpoint: endpoint,
+			Connections:        connection.NewDynamoDB(dynamoDbSvc, table),
+			Presence:           presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
+			SQS:                sqs.New(sess),
+			SQSURL:             queue,
 		},
 	))
 }
 
-func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) error {
-	return func(ctx context.Context, sqsEvent events.SQSEvent) error {
-
+func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+		res := events.SQSEventResponse{}
 		for _, message := range sqsEvent.Records {
-			// indicate start of the processing
-			d.Logger.Info("handling delete connection request",
-				zap.String("payload", message.Body),
-			)
-
-			// get the delete request
-			r, err := request.DeleteConnectionFromString(message.Body)
+			// only the failed messages are redelivered, the rest
+			// of the batch is processed anyway
+			err := deleteConnection(d, message)
 			if err != nil {
-				d.Logger.Error("could not parse request body",
-					zap.String("payload", message.Body),
-					zap.Error(err),
-				)
-				return fmt.Errorf("could not parse request: %s", err)
+				res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: message.MessageId,
+				})
 			}
+		}
 
-			// send notification about the deletion into the connection
-			_, err = d.ApiGateway.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
-				ConnectionId: aws.String(r.ConnectionId),
-				Data:         []byte("$disconnect"),
-			})
-			if err != nil {
-				d.Logger.Error("could not send deletion notification into the connection",
-					zap.String("connectionId", r.ConnectionId),
-					zap.Error(err),
-				)
-				return err
-			}
+		return res, nil
+	}
+}
 
-			// delete the connection
-			_, err = d.ApiGateway.DeleteConnection(&apigatewaymanagementapi.DeleteConnectionInput{
-				ConnectionId: aws.String(r.ConnectionId),
-			})
+// deleteConnection closes the connection unless the request is stale
+func deleteConnection(d handlerDependencies, message events.SQSMessage) error {
+	// indicate start of the processing
+	d.Logger.Info("handling delete connection request",
+		zap.String("payload", message.Body),
+	)
 
-			if err != nil {
-				d.Logger.Error("could not delete the connection",
-					zap.String("connectionId", r.ConnectionId),
-					zap.Error(err),
-				)
-				return err
-			}
+	// get the delete request
+	r, err := request.DeleteConnectionFromString(message.Body)
+	if err != nil {
+		d.Logger.Error("could not parse request body",
+			zap.String("payload", message.Body),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not parse request: %s", err)
+	}
+
+	// the scheduled deletion is valid only for the current session
+	if r.ExpiresAt > 0 {
+		due, err := scheduledDeletionDue(d, r)
+		if err != nil {
+			d.Logger.Error("could not check scheduled deletion",
+				zap.String("connectionId", r.ConnectionId),
+				zap.Error(err),
+			)
+			return err
+		}
+
+		if !due {
+			return nil
+		}
+	}
+
+	d.Logger.Info("deleting connection",
+		zap.String("connectionId", r.ConnectionId),
+		zap.String("reason", r.Reason),
+	)
+
+	// send notification about the deletion into the connection,
+	// the clients learn why the connection was closed from the
+	// structured frame, the requests without the reason keep
+	// the original notice
+	data := []byte("$disconnect")
+	if r.Reason != "" {
+		data = []byte(notification.CloseFrame(r.Reason).String())
+	}
+
+	_, err = d.ApiGateway.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(r.ConnectionId),
+		Data:         data,
+	})
+	if err != nil {
+		if apigw.ClassifyError(err) == apigw.ErrorGone {
+			return gone(d, r.ConnectionId)
 		}
 
-		return nil
+		// the connection is closed even if the client
+		// doesn't learn why
+		d.Logger.Warn("could not send deletion notification into the connection",
+			zap.String("connectionId", r.ConnectionId),
+			zap.Error(err),
+		)
+	}
+
+	// delete the connection
+	_, err = d.ApiGateway.DeleteConnection(&apigatewaymanagementapi.DeleteConnectionInput{
+		ConnectionId: aws.String(r.ConnectionId),
+	})
+	if err != nil {
+		if apigw.ClassifyError(err) == apigw.ErrorGone {
+			return gone(d, r.ConnectionId)
+		}
+
+		d.Logger.Error("could not delete the connection",
+			zap.String("connectionId", r.ConnectionId),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// gone removes the record of the connection which was already closed,
+// $disconnect is not always delivered so the record would stay forever
+// and the connection would be closed again and again
+func gone(d handlerDependencies, connectionId string) error {
+	d.Logger.Info("connection is gone, removing it",
+		zap.String("connectionId", connectionId),
+	)
+
+	return presence.Forget(d.Connections, d.Presence, connectionId)
+}
+
+// scheduledDeletionDue tells whether the session the request was scheduled
+// for has expired, requests still in the future are re-enqueued since
+// SQS can't delay messages for more than 15 minutes
+func scheduledDeletionDue(d handlerDependencies, r request.DeleteConnection) (bool, error) {
+	// the expired record is still read, the deletion delivered
+	// late has to close the connection anyway
+	c, err := d.Connections.Find(r.ConnectionId)
+	if errors.Is(err, connection.ErrNotFound) {
+		d.Logger.Info("connection already closed",
+			zap.String("connectionId", r.ConnectionId),
+		)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
+
+	// the session was extended or shortened by re-authentication,
+	// the deletion was scheduled again for the new expiration
+	if c.SessionExpiresAt != r.ExpiresAt {
+		d.Logger.Info("session changed, ignoring scheduled deletion",
+			zap.String("connectionId", r.ConnectionId),
+			zap.Int64("expiresAt", r.ExpiresAt),
+			zap.Int64("sessionExpiresAt", c.SessionExpiresAt),
+		)
+		return false, nil
+	}
+
+	if r.Remaining() > time.Second {
+		d.Logger.Info("session not expired yet, rescheduling deletion",
+			zap.String("connectionId", r.ConnectionId),
+			zap.Duration("remaining", r.Remaining()),
+		)
+		return false, r.DeleteDelayedSQS(d.SQS, d.SQSURL)
+	}
+
+	return true, nil
 }
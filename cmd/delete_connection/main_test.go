@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+const deleteQueue = "delete"
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	presence    *presence.Memory
+	sqs         *awstest.SQS
+	apiGateway  *awstest.ApiGateway
+}
+
+// newTestDependencies creates the dependencies with the connection of
+// the user whose session expires at the given time
+func newTestDependencies(t *testing.T, sessionExpiresAt int64) testDependencies {
+	t.Helper()
+
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		presence:    presence.NewMemory(),
+		sqs:         awstest.NewSQS(),
+		apiGateway:  awstest.NewApiGateway(),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:      zap.NewNop(),
+		ApiGateway:  td.apiGateway,
+		Connections: td.connections,
+		Presence:    td.presence,
+		SQS:         td.sqs,
+		SQSURL:      deleteQueue,
+	}
+
+	c := connection.New("c1", "1234")
+	c.SessionExpiresAt = sessionExpiresAt
+	if err := td.connections.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = td.presence.Acquire("1234", 0)
+
+	return td
+}
+
+func handle(t *testing.T, d handlerDependencies, r request.DeleteConnection) events.SQSEventResponse {
+	t.Helper()
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := handler(d)(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{
+			{
+				MessageId: "m1",
+				Body:      string(body),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestDeleteConnection(t *testing.T) {
+	td := newTestDependencies(t, time.Now().Add(time.Hour).Unix())
+
+	res := handle(t, td.handlerDependencies, request.DeleteConnection{
+		ConnectionId: "c1",
+		Reason:       request.ReasonRevoked,
+	})
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+
+	// the client learns why before the connection is closed
+	if posted := td.apiGateway.Posted("c1"); len(posted) != 1 || posted[0] != `{"type":"close","code":"revoked"}` {
+		t.Fatalf("unexpected close frame %v", posted)
+	}
+	if deleted := td.apiGateway.Deleted(); !reflect.DeepEqual(deleted, []string{"c1"}) {
+		t.Fatalf("expected c1 deleted, got %v", deleted)
+	}
+}
+
+func TestDeleteConnectionScheduled(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name             string
+		sessionExpiresAt int64
+		expiresAt        int64
+		deleted          bool
+		rescheduled      bool
+	}{
+		{
+			name:             "session expired",
+			sessionExpiresAt: now.Add(-time.Minute).Unix(),
+			expiresAt:        now.Add(-time.Minute).Unix(),
+			deleted:          true,
+		},
+		{
+			name:             "session too long for a single delay",
+			sessionExpiresAt: now.Add(time.Hour).Unix(),
+			expiresAt:        now.Add(time.Hour).Unix(),
+			rescheduled:      true,
+		},
+		{
+			name:             "session extended by re-authentication",
+			sessionExpiresAt: now.Add(time.Hour).Unix(),
+			expiresAt:        now.Add(-time.Minute).Unix(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDependencies(t, tt.sessionExpiresAt)
+
+			res := handle(t, td.handlerDependencies, request.DeleteConnectionAt("c1", tt.expiresAt))
+			if len(res.BatchItemFailures) > 0 {
+				t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+			}
+
+			if deleted := len(td.apiGateway.Deleted()) > 0; deleted != tt.deleted {
+				t.Fatalf("expected deleted %v, got %v", tt.deleted, deleted)
+			}
+
+			// the request is sent back with the longest delay and
+			// the same expiration
+			messages := td.sqs.Messages(deleteQueue)
+			if rescheduled := len(messages) > 0; rescheduled != tt.rescheduled {
+				t.Fatalf("expected rescheduled %v, got %v", tt.rescheduled, rescheduled)
+			}
+			if tt.rescheduled {
+				r, err := request.DeleteConnectionFromString(aws.StringValue(messages[0].MessageBody))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.ExpiresAt != tt.expiresAt || aws.Int64Value(messages[0].DelaySeconds) != int64(request.MaxDelay.Seconds()) {
+					t.Fatalf("unexpected rescheduled request %+v delayed by %d", r, aws.Int64Value(messages[0].DelaySeconds))
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteConnectionScheduledClosed(t *testing.T) {
+	td := newTestDependencies(t, time.Now().Add(-time.Minute).Unix())
+	if err := td.connections.Delete("c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection closed meanwhile is left alone
+	res := handle(t, td.handlerDependencies, request.DeleteConnectionAt("c1", time.Now().Add(-time.Minute).Unix()))
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+	if deleted := td.apiGateway.Deleted(); len(deleted) > 0 {
+		t.Fatalf("expected no deletion, got %v", deleted)
+	}
+}
+
+func TestDeleteConnectionGone(t *testing.T) {
+	td := newTestDependencies(t, time.Now().Add(time.Hour).Unix())
+	td.apiGateway.Errors["c1"] = awserr.New(apigatewaymanagementapi.ErrCodeGoneException, "gone", nil)
+
+	res := handle(t, td.handlerDependencies, request.DeleteConnectionFromId("c1"))
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+
+	// the record of the connection closed without $disconnect is removed
+	if _, err := td.connections.Find("c1"); err != connection.ErrNotFound {
+		t.Fatalf("expected record removed, got %v", err)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 0 {
+		t.Fatalf("expected no counted connections, got %d", count)
+	}
+}
+
+func TestDeleteConnectionFailed(t *testing.T) {
+	td := newTestDependencies(t, time.Now().Add(time.Hour).Unix())
+	td.apiGateway.Errors["c1"] = awserr.New(apigatewaymanagementapi.ErrCodeLimitExceededException, "slow down", nil)
+
+	// the request is redelivered
+	res := handle(t, td.handlerDependencies, request.DeleteConnectionFromId("c1"))
+	if len(res.BatchItemFailures) != 1 || res.BatchItemFailures[0].ItemIdentifier != "m1" {
+		t.Fatalf("expected failure of m1, got %v", res.BatchItemFailures)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 
@@ -12,13 +14,14 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
 	connection "github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
 	"go.uber.org/zap"
 )
 
 type handlerDependencies struct {
-	DynamoDB  *dynamodb.DynamoDB
-	Logger    *zap.Logger
-	TableName string
+	Connections connection.ConnectionStore
+	Presence    presence.Store
+	Logger      *zap.Logger
 }
 
 func main() {
@@ -40,9 +43,9 @@ func main() {
 	lambda.Start(
 		handler(
 			handlerDependencies{
-				DynamoDB:  dynamoDbSvc,
-				Logger:    logger,
-				TableName: table,
+				Connections: connection.NewDynamoDB(dynamoDbSvc, table),
+				Presence:    presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
+				Logger:      logger,
 			},
 		),
 	)
@@ -59,15 +62,42 @@ func handler(d handlerDependencies) func(_ context.Context, req *events.APIGatew
 			zap.String("connectionId", connectionId),
 		)
 
-		// delete the connection
-		err := connection.NewWithConnectionId(connectionId).Delete(d.DynamoDB, d.TableName)
-		if err != nil {
+		// delete the connection and get its user, the expired record
+		// still has to be uncounted, the record removed meanwhile by
+		// presence.Forget was uncounted already
+		c, err := d.Connections.Remove(connectionId)
+		if err != nil && !errors.Is(err, connection.ErrNotFound) {
 			d.Logger.Error("could not delete dynamodb record",
 				zap.Error(err),
 			)
 			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not delete DynamoDB record: %s", err)
 		}
 
+		// the user was seen until now
+		if c.UserId != "" {
+			err = d.Presence.Touch(c.UserId, time.Now().Unix())
+			if err != nil {
+				d.Logger.Error("could not update presence",
+					zap.String("userId", c.UserId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), fmt.Errorf("could not update presence: %s", err)
+			}
+		}
+
+		// the connection no longer counts towards the limit, the
+		// evicted connections were uncounted already
+		if c.Counted() {
+			err = d.Presence.Release(c.UserId)
+			if err != nil {
+				d.Logger.Error("could not release connection of the user",
+					zap.String("userId", c.UserId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), fmt.Errorf("could not release connection: %s", err)
+			}
+		}
+
 		// all good
 		return apigw.OkResponse(), nil
 	}
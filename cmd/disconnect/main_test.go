@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"go.uber.org/zap"
+)
+
+func disconnect(t *testing.T, d handlerDependencies, connectionId string) {
+	t.Helper()
+
+	res, err := handler(d)(context.Background(), &events.APIGatewayWebsocketProxyRequest{
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: connectionId,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		userId   string
+		expired  bool
+		evicted  bool
+		counted  int
+		existing bool
+	}{
+		{
+			name:     "user connection",
+			userId:   "1234",
+			counted:  0,
+			existing: true,
+		},
+		{
+			name:     "expired connection is still released",
+			userId:   "1234",
+			expired:  true,
+			counted:  0,
+			existing: true,
+		},
+		{
+			name:     "evicted connection was released already",
+			userId:   "1234",
+			evicted:  true,
+			counted:  1,
+			existing: true,
+		},
+		{
+			name:     "anonymous connection",
+			existing: true,
+		},
+		{
+			name: "unknown connection",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connections := connection.NewMemory()
+			p := presence.NewMemory()
+			d := handlerDependencies{
+				Connections: connections,
+				Presence:    p,
+				Logger:      zap.NewNop(),
+			}
+
+			if tt.existing {
+				c := connection.New("c1", tt.userId)
+				c.SessionExpiresAt = time.Now().Add(time.Hour).Unix()
+				if tt.expired {
+					c.SessionExpiresAt = time.Now().Add(-2 * connection.ExpiryGrace).Unix()
+				}
+				if err := connections.Create(c); err != nil {
+					t.Fatal(err)
+				}
+
+				if tt.userId != "" {
+					_, _ = p.Acquire(tt.userId, 0)
+				}
+			}
+
+			// the evicted connection was uncounted by the connect
+			// which evicted it, the counter belongs to the other one
+			if tt.evicted {
+				if err := connections.Evict("c1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			disconnect(t, d, "c1")
+
+			if _, err := connections.Find("c1"); err != connection.ErrNotFound {
+				t.Fatalf("expected deleted connection, got %v", err)
+			}
+
+			if tt.userId == "" {
+				return
+			}
+
+			if count, _ := p.Connections(tt.userId); count != tt.counted {
+				t.Fatalf("expected %d counted connections, got %d", tt.counted, count)
+			}
+
+			// the user was seen until the disconnect
+			seen, _ := p.LastSeen([]string{tt.userId})
+			if seen[tt.userId] == 0 {
+				t.Fatalf("expected the user to be seen, got %v", seen)
+			}
+		})
+	}
+}
+
+func TestDisconnectForgotten(t *testing.T) {
+	for _, forgetFirst := range []bool{true, false} {
+		connections := connection.NewMemory()
+		p := presence.NewMemory()
+		d := handlerDependencies{
+			Connections: connections,
+			Presence:    p,
+			Logger:      zap.NewNop(),
+		}
+
+		storetest.Open(t, connections, p, "1234", "c1", "c2")
+
+		// the connection reported gone by the management API gets
+		// $disconnect as well, it's uncounted only once
+		if forgetFirst {
+			if err := presence.Forget(connections, p, "c1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		disconnect(t, d, "c1")
+		if !forgetFirst {
+			if err := presence.Forget(connections, p, "c1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if count, _ := p.Connections("1234"); count != 1 {
+			t.Fatalf("expected 1 counted connection with forget first %v, got %d", forgetFirst, count)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger          *zap.Logger
+	Validator       token.Validator
+	SimpleResponses bool
+}
+
+func main() {
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Validator: token.Validator{
+					Keys:        token.NewJWKSSource(os.Getenv("CONFIG_JWKS_URL"), keystore.CacheTTL),
+					Audience:    os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+					Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+				},
+				SimpleResponses: os.Getenv("CONFIG_AUTHORIZER_SIMPLE_RESPONSES") == "true",
+			},
+		),
+	)
+}
+
+// handler authorizes REST routes with the bearer token, the response
+// format has to match the enableSimpleResponses setting of the authorizer
+func handler(d handlerDependencies) func(_ context.Context, req *apigw.APIGatewayV2CustomAuthorizerRequest) (interface{}, error) {
+	return func(_ context.Context, req *apigw.APIGatewayV2CustomAuthorizerRequest) (interface{}, error) {
+
+		// verify the token
+		claims, err := d.Validator.Validate(apigw.BearerToken(req.Headers))
+		if err != nil {
+			d.Logger.Info("invalid token",
+				zap.String("routeArn", req.RouteARN),
+				zap.Error(err),
+			)
+
+			if d.SimpleResponses {
+				return apigw.AuthorizerSimpleDeny(), nil
+			}
+			return apigw.AuthorizerDeny(req.RouteARN), nil
+		}
+
+		d.Logger.Info("request authorized",
+			zap.String("routeArn", req.RouteARN),
+			zap.String("userId", claims.UserId),
+			zap.String("jti", claims.Id),
+			zap.String("fam", claims.Family()),
+		)
+
+		// pass the claims to the route handlers
+		values := map[string]interface{}{
+			apigw.AuthorizerUserIdKey:   claims.UserId,
+			apigw.AuthorizerTokenIdKey:  claims.Family(),
+			apigw.AuthorizerScopesKey:   claims.Scope,
+			apigw.AuthorizerExpiresKey:  claims.ExpiresAt,
+			apigw.AuthorizerAuthTimeKey: claims.AuthenticatedAt(),
+		}
+
+		if d.SimpleResponses {
+			res := apigw.AuthorizerSimpleAllow()
+			res.Context = values
+
+			// all good
+			return res, nil
+		}
+
+		// the policy is cached by the identity source, so it has to
+		// allow all routes the token can be used for
+		res := apigw.AuthorizerAllow(apigw.WildcardRouteARN(req.RouteARN), claims.UserId)
+		res.Context = values
+
+		// all good
+		return res, nil
+	}
+}
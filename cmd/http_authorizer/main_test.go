@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pipetail/sst-websocket/internal/tokentest"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"go.uber.org/zap"
+)
+
+const routeArn = "arn:aws:execute-api:eu-west-1:123456789012:abcdef/prod/POST/token/refresh"
+
+func authorizeRequest(t *testing.T, simple bool, revoked bool) interface{} {
+	t.Helper()
+
+	keys := tokentest.Keys(t)
+	tok, claims, err := tokentest.Issuer(keys).Issue("1234", []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revocations := revocation.NewMemory()
+	if revoked {
+		if err := revocations.Revoke(claims.Family(), claims.ExpiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := handler(handlerDependencies{
+		Logger:          zap.NewNop(),
+		Validator:       tokentest.Validator(keys, revocations),
+		SimpleResponses: simple,
+	})(context.Background(), &apigw.APIGatewayV2CustomAuthorizerRequest{
+		RouteARN: routeArn,
+		Headers:  map[string]string{"authorization": "Bearer " + tok},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestHTTPAuthorizerSimple(t *testing.T) {
+	res, ok := authorizeRequest(t, true, false).(apigw.APIGatewayV2SimpleAuthorizerResponse)
+	if !ok || !res.IsAuthorized {
+		t.Fatalf("expected authorized request, got %+v", res)
+	}
+	if res.Context[apigw.AuthorizerUserIdKey] != "1234" || res.Context[apigw.AuthorizerScopesKey] != "ping" {
+		t.Fatalf("unexpected context %v", res.Context)
+	}
+
+	res, ok = authorizeRequest(t, true, true).(apigw.APIGatewayV2SimpleAuthorizerResponse)
+	if !ok || res.IsAuthorized {
+		t.Fatalf("expected denied request, got %+v", res)
+	}
+}
+
+func TestHTTPAuthorizerPolicy(t *testing.T) {
+	res, ok := authorizeRequest(t, false, false).(apigw.APIGatewayV2CustomAuthorizerResponse)
+	if !ok || res.PrincipalId != "1234" {
+		t.Fatalf("expected policy of the user, got %+v", res)
+	}
+
+	// the cached policy allows all routes of the stage
+	statement := res.PolicyDocument.Statement[0]
+	if statement.Effect != "Allow" || statement.Resource != "arn:aws:execute-api:eu-west-1:123456789012:abcdef/prod/*" {
+		t.Fatalf("unexpected statement %+v", statement)
+	}
+	if res.Context[apigw.AuthorizerTokenIdKey] == "" {
+		t.Fatalf("expected token family in the context, got %v", res.Context)
+	}
+
+	res, ok = authorizeRequest(t, false, true).(apigw.APIGatewayV2CustomAuthorizerResponse)
+	if !ok || res.PolicyDocument.Statement[0].Effect != "Deny" || res.PolicyDocument.Statement[0].Resource != routeArn {
+		t.Fatalf("expected denied route, got %+v", res)
+	}
+}
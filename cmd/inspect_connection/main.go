@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+// requiredScope has to be granted to inspect connections
+const requiredScope = "admin"
+
+// inconsistencies between the record and API Gateway
+const (
+	inconsistencyStaleRecord    = "record_without_connection"
+	inconsistencyMissingRecord  = "connection_without_record"
+	inconsistencySessionExpired = "session_expired"
+	inconsistencySourceIp       = "source_ip_mismatch"
+)
+
+type handlerDependencies struct {
+	Logger             *zap.Logger
+	ApiGateway         apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+	ApiGatewayEndpoint string
+	Connections        connection.ConnectionStore
+}
+
+// liveConnection is the state of the connection reported by API Gateway
+type liveConnection struct {
+	ConnectedAt  *time.Time `json:"connectedAt,omitempty"`
+	LastActiveAt *time.Time `json:"lastActiveAt,omitempty"`
+	SourceIp     string     `json:"sourceIp,omitempty"`
+	UserAgent    string     `json:"userAgent,omitempty"`
+}
+
+type inspectResponse struct {
+	ConnectionId    string                 `json:"connectionId"`
+	Record          *connection.Connection `json:"record"`
+	Expired         bool                   `json:"expired"`
+	Live            *liveConnection        `json:"live"`
+	Inconsistencies []string               `json:"inconsistencies"`
+}
+
+func main() {
+	// get API Gateway endpoint
+	endpoint := apigw.SanitizeURL(os.Getenv("CONFIG_API_GATEWAY_ENDPOINT"))
+
+	// create apigateway client
+	apiGatewaySess, _ := session.NewSession(&aws.Config{
+		Endpoint: aws.String(endpoint),
+	})
+	apiGatewaySvc := apigatewaymanagementapi.New(apiGatewaySess)
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// get dynamodb table name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(handler(
+		handlerDependencies{
+			Logger:             logger,
+			ApiGateway:         apiGatewaySvc,
+			ApiGatewayEndpoint: endpoint,
+			Connections:        connection.NewDynamoDB(dynamodb.New(sess), table),
+		},
+	))
+}
+
+// handler returns the stored record of the connection together with the
+// live state reported by API Gateway and the differences between them
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// only admins can inspect connections of other users
+		scopes, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerScopesKey)
+		if !token.HasScopes(token.SplitScopes(scopes), requiredScope) {
+			d.Logger.Info("missing scope to inspect connection")
+			return apigw.ForbiddenResponse(), nil
+		}
+
+		// get the connection to inspect
+		connectionId := req.PathParameters["id"]
+		if connectionId == "" {
+			return apigw.BadRequestResponse(), nil
+		}
+
+		res := inspectResponse{
+			ConnectionId:    connectionId,
+			Inconsistencies: []string{},
+		}
+
+		// get the stored record, the expired record still waiting for
+		// the TTL deletion is reported too and flagged as expired
+		c, err := d.Connections.Find(connectionId)
+		if err != nil && !errors.Is(err, connection.ErrNotFound) {
+			d.Logger.Error("could not get connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not get connection: %s", err)
+		}
+		if err == nil {
+			res.Record = &c
+			res.Expired = c.Expired()
+		}
+
+		// get the live state, API Gateway reports closed connections as gone
+		live, err := d.ApiGateway.GetConnection(&apigatewaymanagementapi.GetConnectionInput{
+			ConnectionId: aws.String(connectionId),
+		})
+
+		if err != nil && apigw.ClassifyError(err) != apigw.ErrorGone {
+			d.Logger.Error("could not get live connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not get live connection: %s", err)
+		}
+		if err == nil {
+			res.Live = &liveConnection{
+				ConnectedAt:  live.ConnectedAt,
+				LastActiveAt: live.LastActiveAt,
+			}
+			if live.Identity != nil {
+				res.Live.SourceIp = aws.StringValue(live.Identity.SourceIp)
+				res.Live.UserAgent = aws.StringValue(live.Identity.UserAgent)
+			}
+		}
+
+		// flag the differences
+		switch {
+		case res.Record == nil && res.Live == nil:
+			return apigw.JSONResponse(http.StatusNotFound, res)
+		case res.Record != nil && res.Live == nil:
+			res.Inconsistencies = append(res.Inconsistencies, inconsistencyStaleRecord)
+		case res.Record == nil && res.Live != nil:
+			res.Inconsistencies = append(res.Inconsistencies, inconsistencyMissingRecord)
+		default:
+			if c.SessionExpiresAt > 0 && c.SessionExpiresAt < time.Now().Unix() {
+				res.Inconsistencies = append(res.Inconsistencies, inconsistencySessionExpired)
+			}
+			if c.SourceIp != "" && res.Live.SourceIp != "" && c.SourceIp != res.Live.SourceIp {
+				res.Inconsistencies = append(res.Inconsistencies, inconsistencySourceIp)
+			}
+		}
+
+		d.Logger.Info("connection inspected",
+			zap.String("connectionId", connectionId),
+			zap.Bool("record", res.Record != nil),
+			zap.Bool("expired", res.Expired),
+			zap.Bool("live", res.Live != nil),
+			zap.Strings("inconsistencies", res.Inconsistencies),
+		)
+
+		// all good
+		return apigw.JSONResponse(http.StatusOK, res)
+	}
+}
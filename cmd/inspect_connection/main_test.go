@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"go.uber.org/zap"
+)
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	apiGateway  *awstest.ApiGateway
+}
+
+func newTestDependencies() testDependencies {
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		apiGateway:  awstest.NewApiGateway(),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:      zap.NewNop(),
+		ApiGateway:  td.apiGateway,
+		Connections: td.connections,
+	}
+
+	return td
+}
+
+func (td testDependencies) inspect(t *testing.T, connectionId string, scopes string) (int, inspectResponse) {
+	t.Helper()
+
+	res, err := handler(td.handlerDependencies)(context.Background(), events.APIGatewayV2HTTPRequest{
+		PathParameters: map[string]string{"id": connectionId},
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				Lambda: map[string]interface{}{"scopes": scopes},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := inspectResponse{}
+	if res.Body != "" {
+		if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode, body
+}
+
+func TestInspectConnection(t *testing.T) {
+	td := newTestDependencies()
+
+	c := connection.New("c1", "1234")
+	c.SourceIp = "10.0.0.1"
+	if err := td.connections.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	td.apiGateway.Live["c1"] = &apigatewaymanagementapi.GetConnectionOutput{
+		ConnectedAt: aws.Time(time.Now()),
+		Identity: &apigatewaymanagementapi.Identity{
+			SourceIp: aws.String("10.0.0.2"),
+		},
+	}
+
+	status, res := td.inspect(t, "c1", "admin")
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if res.Record == nil || res.Live == nil || res.Expired {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	if len(res.Inconsistencies) != 1 || res.Inconsistencies[0] != inconsistencySourceIp {
+		t.Fatalf("expected source ip mismatch, got %v", res.Inconsistencies)
+	}
+}
+
+func TestInspectConnectionExpired(t *testing.T) {
+	td := newTestDependencies()
+
+	// the record waits for the TTL deletion, API Gateway closed the connection
+	c := connection.New("c1", "1234")
+	c.SessionExpiresAt = time.Now().Add(-connection.ExpiryGrace - time.Minute).Unix()
+	if err := td.connections.Create(c); err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := td.inspect(t, "c1", "admin")
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if res.Record == nil || !res.Expired {
+		t.Fatalf("expected expired record, got %+v", res)
+	}
+	if len(res.Inconsistencies) != 1 || res.Inconsistencies[0] != inconsistencyStaleRecord {
+		t.Fatalf("expected stale record, got %v", res.Inconsistencies)
+	}
+}
+
+func TestInspectConnectionNotFound(t *testing.T) {
+	td := newTestDependencies()
+
+	if status, _ := td.inspect(t, "c1", "admin"); status != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestInspectConnectionForbidden(t *testing.T) {
+	td := newTestDependencies()
+
+	if status, _ := td.inspect(t, "c1", "ping"); status != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, status)
+	}
+}
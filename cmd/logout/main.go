@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+// requiredScope has to be granted to log out other users
+const requiredScope = "admin"
+
+type handlerDependencies struct {
+	Logger *zap.Logger
+	SQS    *sqs.SQS
+	SQSURL string
+}
+
+// logoutRequest optionally tells why the user is logged out
+type logoutRequest struct {
+	Reason string `json:"reason"`
+}
+
+func main() {
+	// get logout user queue URL
+	queue := os.Getenv("CONFIG_SQS_LOGOUT_USER_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				SQS:    sqs.New(sess),
+				SQSURL: queue,
+			},
+		),
+	)
+}
+
+// handler requests closing of all connections of the user, the users can
+// log out themselves, the others need the admin scope
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// get the user to log out
+		userId := req.PathParameters["id"]
+		if userId == "" {
+			return apigw.BadRequestResponse(), nil
+		}
+
+		callerId, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerUserIdKey)
+		scopes, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerScopesKey)
+		if callerId != userId && !token.HasScopes(token.SplitScopes(scopes), requiredScope) {
+			d.Logger.Info("missing scope to log out user",
+				zap.String("callerId", callerId),
+				zap.String("userId", userId),
+			)
+			return apigw.ForbiddenResponse(), nil
+		}
+
+		// get the reason
+		r := request.LogoutUser{
+			UserId: userId,
+			Reason: request.ReasonLogout,
+		}
+
+		body, err := apigw.HTTPRequestBody(req)
+		if err != nil {
+			d.Logger.Error("could not decode request body",
+				zap.Error(err),
+			)
+			return apigw.BadRequestResponse(), nil
+		}
+
+		if body != "" {
+			m := logoutRequest{}
+			err = json.Unmarshal([]byte(body), &m)
+			if err != nil || (m.Reason != "" && !request.ValidLogoutReason(m.Reason)) {
+				d.Logger.Info("invalid logout request",
+					zap.String("userId", userId),
+					zap.Error(err),
+				)
+				return apigw.BadRequestResponse(), nil
+			}
+
+			if m.Reason != "" {
+				r.Reason = m.Reason
+			}
+		}
+
+		// the connections are closed asynchronously
+		err = r.LogoutSQS(d.SQS, d.SQSURL)
+		if err != nil {
+			d.Logger.Error("could not request logout",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not request logout: %s", err)
+		}
+
+		d.Logger.Info("logout requested",
+			zap.String("userId", userId),
+			zap.String("callerId", callerId),
+			zap.String("reason", r.Reason),
+		)
+
+		// all good
+		return apigw.AcceptedResponse(), nil
+	}
+}
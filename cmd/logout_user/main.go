@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger      *zap.Logger
+	Connections connection.ConnectionStore
+	Revocations revocation.Store
+	SQS         sqsiface.SQSAPI
+	SQSURL      string
+	MaxLifetime time.Duration
+}
+
+func main() {
+	// get dynamodb table and index name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+	index := os.Getenv("CONFIG_USER_ID_INDEX_NAME")
+
+	// get delete connection queue URL
+	queue := os.Getenv("CONFIG_SQS_DELETE_CONNECTION_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create connection store, the users are looked up by the index
+	connections := connection.NewDynamoDB(dynamoDbSvc, table)
+	connections.UserIdIndex = index
+	connections.UserIdIndexSorted = os.Getenv("CONFIG_USER_ID_INDEX_SORTED") == "true"
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// get maximal lifetime of the token family in seconds
+	maxLifetime, err := strconv.Atoi(os.Getenv("CONFIG_TOKEN_MAX_LIFETIME"))
+	if err != nil {
+		logger.Fatal("could not parse token max lifetime", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(handler(
+		handlerDependencies{
+			Logger:      logger,
+			Connections: connections,
+			Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+			SQS:         sqs.New(sess),
+			SQSURL:      queue,
+			MaxLifetime: time.Duration(maxLifetime) * time.Second,
+		},
+	))
+}
+
+func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+		res := events.SQSEventResponse{}
+		for _, message := range sqsEvent.Records {
+			// only the failed messages are redelivered, the rest
+			// of the batch is processed anyway
+			err := logoutUser(d, message)
+			if err != nil {
+				res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: message.MessageId,
+				})
+			}
+		}
+
+		return res, nil
+	}
+}
+
+// logoutUser revokes all credentials of the user and requests closing
+// of all its connections
+func logoutUser(d handlerDependencies, message events.SQSMessage) error {
+	// indicate start of the processing
+	d.Logger.Info("handling logout request",
+		zap.String("payload", message.Body),
+	)
+
+	// get the logout request
+	r, err := request.LogoutUserFromString(message.Body)
+	if err != nil || r.UserId == "" {
+		d.Logger.Error("could not parse request body",
+			zap.String("payload", message.Body),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not parse request: %s", err)
+	}
+
+	if !request.ValidLogoutReason(r.Reason) {
+		r.Reason = request.ReasonLogout
+	}
+
+	// revoke the credentials first so the user can't reconnect or refresh
+	// the token, no credentials issued before can outlive the maximal
+	// lifetime of the token family
+	now := time.Now()
+	err = d.Revocations.RevokeUser(r.UserId, now.Unix(), now.Add(d.MaxLifetime).Unix())
+	if err != nil {
+		d.Logger.Error("could not revoke credentials of the user",
+			zap.String("userId", r.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not revoke credentials of the user: %s", err)
+	}
+
+	// close all connections of the user through the
+	// delete connection queue
+	count := 0
+	err = d.Connections.EachByUserId(r.UserId, 0, func(c connection.Connection) error {
+		d.Logger.Info("closing connection",
+			zap.String("userId", r.UserId),
+			zap.String("connectionId", c.ConnectionId),
+			zap.String("reason", r.Reason),
+		)
+
+		deleteRequest := request.DeleteConnectionFromId(c.ConnectionId)
+		deleteRequest.Reason = r.Reason
+
+		err := deleteRequest.DeleteSQS(d.SQS, d.SQSURL)
+		if err != nil {
+			d.Logger.Error("could not request deletion of connection",
+				zap.String("connectionId", c.ConnectionId),
+				zap.Error(err),
+			)
+			return err
+		}
+
+		count++
+		return nil
+	})
+	if err != nil {
+		d.Logger.Error("could not log out the user",
+			zap.String("userId", r.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not log out the user: %s", err)
+	}
+
+	d.Logger.Info("user logged out",
+		zap.String("userId", r.UserId),
+		zap.String("reason", r.Reason),
+		zap.Int("connections", count),
+	)
+
+	return nil
+}
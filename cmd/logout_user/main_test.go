@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+const deleteQueue = "delete"
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	revocations *revocation.Memory
+	sqs         *awstest.SQS
+}
+
+func newTestDependencies(t *testing.T, connectionIds ...string) testDependencies {
+	t.Helper()
+
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		revocations: revocation.NewMemory(),
+		sqs:         awstest.NewSQS(),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:      zap.NewNop(),
+		Connections: td.connections,
+		Revocations: td.revocations,
+		SQS:         td.sqs,
+		SQSURL:      deleteQueue,
+		MaxLifetime: time.Hour,
+	}
+
+	storetest.Open(t, td.connections, nil, "1234", connectionIds...)
+
+	return td
+}
+
+func (td testDependencies) logout(t *testing.T, r request.LogoutUser) events.SQSEventResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(r)
+	res, err := handler(td.handlerDependencies)(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{{MessageId: "m1", Body: string(body)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestLogoutUser(t *testing.T) {
+	td := newTestDependencies(t, "c1", "c2")
+	if err := td.connections.Evict("c2"); err != nil {
+		t.Fatal(err)
+	}
+
+	issuedAt := time.Now().Unix() - 1
+	res := td.logout(t, request.LogoutUser{UserId: "1234", Reason: "unknown"})
+	if len(res.BatchItemFailures) != 0 {
+		t.Fatalf("expected no failures, got %v", res.BatchItemFailures)
+	}
+
+	// the credentials issued before the logout are revoked, the ones
+	// issued after it are not
+	v := token.Validator{Revocations: td.revocations}
+	if err := v.CheckUserRevoked("1234", issuedAt); !errors.Is(err, token.ErrRevoked) {
+		t.Fatalf("expected revoked credentials, got %v", err)
+	}
+	if err := v.CheckUserRevoked("1234", time.Now().Unix()+1); err != nil {
+		t.Fatalf("expected the new credentials to be valid, got %v", err)
+	}
+
+	// the evicted connection is already being closed
+	messages := td.sqs.Messages(deleteQueue)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 deletion, got %d", len(messages))
+	}
+	r, err := request.DeleteConnectionFromString(aws.StringValue(messages[0].MessageBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ConnectionId != "c1" || r.Reason != request.ReasonLogout {
+		t.Fatalf("unexpected deletion %+v", r)
+	}
+}
+
+func TestLogoutUserQueueFailure(t *testing.T) {
+	td := newTestDependencies(t, "c1")
+	td.sqs.Errors[deleteQueue] = errors.New("queue is down")
+
+	res := td.logout(t, request.LogoutUser{UserId: "1234"})
+	if len(res.BatchItemFailures) != 1 {
+		t.Fatalf("expected the message to be redelivered, got %v", res.BatchItemFailures)
+	}
+}
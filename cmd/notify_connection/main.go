@@ -10,15 +10,28 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
 	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
 	"go.uber.org/zap"
 )
 
 type handlerDependencies struct {
 	Logger             *zap.Logger
-	ApiGateway         *apigatewaymanagementapi.ApiGatewayManagementApi
+	ApiGateway         apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
 	ApiGatewayEndpoint string
+	Connections        connection.ConnectionStore
+	Presence           presence.Store
+	SQS                sqsiface.SQSAPI
+
+	// DeadLetterURL receives the notifications which can't be
+	// delivered no matter how often they are retried
+	DeadLetterURL string
 }
 
 func main() {
@@ -31,6 +44,14 @@ func main() {
 	})
 	apiGatewaySvc := apigatewaymanagementapi.New(apiGatewaySess)
 
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
 	// create a logger
 	logger, _ := zap.NewProduction()
 
@@ -40,47 +61,101 @@ func main() {
 			Logger:             logger,
 			ApiGateway:         apiGatewaySvc,
 			ApiGatewayEndpoint: endpoint,
+			Connections:        connection.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")),
+			Presence:           presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
+			SQS:                sqs.New(sess),
+			DeadLetterURL:      os.Getenv("CONFIG_SQS_NOTIFY_CONNECTION_DLQ_URL"),
 		},
 	))
 }
 
-func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) error {
-	return func(ctx context.Context, sqsEvent events.SQSEvent) error {
+func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 
 		// we can handle even the bigger batches but the ideal
 		// batch is 1 to send the message as soon as possible
 		// especially in some smaller applications with low
 		// traffic
+		res := events.SQSEventResponse{}
 		for _, message := range sqsEvent.Records {
-			// indicate start of the processing
-			d.Logger.Info("handling notification",
-				zap.String("payload", message.Body),
-			)
-
-			// get the notification
-			n, err := notification.ConnectionFromString(message.Body)
+			// only the failed messages are redelivered, the rest
+			// of the batch is processed anyway
+			err := notifyConnection(d, message)
 			if err != nil {
-				d.Logger.Error("could not parse notification body",
-					zap.String("payload", message.Body),
-					zap.Error(err),
-				)
-				return fmt.Errorf("could not parse notification: %s", err)
+				res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: message.MessageId,
+				})
 			}
+		}
 
-			// send message to connection
-			_, err = d.ApiGateway.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
-				ConnectionId: aws.String(n.ConnectionId),
-				Data:         []byte(n.Data),
-			})
-			if err != nil {
-				// fail if connection was not notified, maybe the message
-				// will be eventually delivered
-				d.Logger.Error("could not notify the connection",
-					zap.String("connectionId", n.ConnectionId),
-					zap.Error(err),
-				)
-				return err
-			}
+		return res, nil
+	}
+}
+
+// notifyConnection delivers the notification into the connection
+func notifyConnection(d handlerDependencies, message events.SQSMessage) error {
+	// indicate start of the processing
+	d.Logger.Info("handling notification",
+		zap.String("payload", message.Body),
+	)
+
+	// get the notification
+	n, err := notification.ConnectionFromString(message.Body)
+	if err != nil {
+		d.Logger.Error("could not parse notification body",
+			zap.String("payload", message.Body),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not parse notification: %s", err)
+	}
+
+	// send message to connection
+	_, err = d.ApiGateway.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(n.ConnectionId),
+		Data:         []byte(n.Data),
+	})
+	if err != nil {
+		err = handleError(d, n, message.Body, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleError decides about the notification which was not delivered, the
+// closed connections are forgotten, the throttled notifications are retried
+// and the others are moved to the dead-letter queue, the notification is
+// acknowledged unless an error is returned
+func handleError(d handlerDependencies, n notification.ConnectionNotification, body string, err error) error {
+	switch apigw.ClassifyError(err) {
+	case apigw.ErrorGone:
+		d.Logger.Info("connection is gone, removing it",
+			zap.String("connectionId", n.ConnectionId),
+		)
+		return presence.Forget(d.Connections, d.Presence, n.ConnectionId)
+
+	case apigw.ErrorRetryable:
+		// fail so the message is redelivered later
+		d.Logger.Warn("could not notify the connection, retrying",
+			zap.String("connectionId", n.ConnectionId),
+			zap.Error(err),
+		)
+		return err
+
+	default:
+		d.Logger.Error("could not notify the connection, moving to dead-letter queue",
+			zap.String("connectionId", n.ConnectionId),
+			zap.Error(err),
+		)
+
+		_, err = d.SQS.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:    aws.String(d.DeadLetterURL),
+			MessageBody: aws.String(body),
+		})
+		if err != nil {
+			return fmt.Errorf("could not move notification to dead-letter queue: %s", err)
 		}
 
 		return nil
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"go.uber.org/zap"
+)
+
+const deadLetterURL = "dead-letter"
+
+func TestNotifyConnection(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		dlqErr       error
+		failed       bool
+		delivered    bool
+		deadLettered bool
+		removed      bool
+	}{
+		{
+			name:      "delivered",
+			delivered: true,
+		},
+		{
+			name:    "gone connection is forgotten",
+			err:     awserr.New(apigatewaymanagementapi.ErrCodeGoneException, "gone", nil),
+			removed: true,
+		},
+		{
+			name:   "throttled notification is retried",
+			err:    awserr.New(apigatewaymanagementapi.ErrCodeLimitExceededException, "slow down", nil),
+			failed: true,
+		},
+		{
+			name:         "permanent failure is dead-lettered",
+			err:          awserr.New(apigatewaymanagementapi.ErrCodeForbiddenException, "forbidden", nil),
+			deadLettered: true,
+		},
+		{
+			name:   "permanent failure is retried without dead-letter queue",
+			err:    awserr.New(apigatewaymanagementapi.ErrCodeForbiddenException, "forbidden", nil),
+			dlqErr: errors.New("queue is down"),
+			failed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connections := connection.NewMemory()
+			p := presence.NewMemory()
+			storetest.Open(t, connections, p, "1234", "c1")
+
+			apiGateway := awstest.NewApiGateway()
+			if tt.err != nil {
+				apiGateway.Errors["c1"] = tt.err
+			}
+			s := awstest.NewSQS()
+			if tt.dlqErr != nil {
+				s.Errors[deadLetterURL] = tt.dlqErr
+			}
+
+			body, _ := json.Marshal(notification.ConnectionNotification{ConnectionId: "c1", Data: "hello"})
+			res, err := handler(handlerDependencies{
+				Logger:        zap.NewNop(),
+				ApiGateway:    apiGateway,
+				Connections:   connections,
+				Presence:      p,
+				SQS:           s,
+				DeadLetterURL: deadLetterURL,
+			})(context.Background(), events.SQSEvent{
+				Records: []events.SQSMessage{{MessageId: "m1", Body: string(body)}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if failed := len(res.BatchItemFailures) > 0; failed != tt.failed {
+				t.Fatalf("expected failed %v, got %v", tt.failed, res.BatchItemFailures)
+			}
+			if delivered := len(apiGateway.Posted("c1")) > 0; delivered != tt.delivered {
+				t.Fatalf("expected delivered %v, got %v", tt.delivered, delivered)
+			}
+
+			messages := s.Messages(deadLetterURL)
+			if deadLettered := len(messages) > 0; deadLettered != tt.deadLettered {
+				t.Fatalf("expected dead-lettered %v, got %v", tt.deadLettered, deadLettered)
+			}
+			if tt.deadLettered && aws.StringValue(messages[0].MessageBody) != string(body) {
+				t.Fatalf("expected the original message dead-lettered, got %s", aws.StringValue(messages[0].MessageBody))
+			}
+
+			// the gone connection is uncounted
+			_, err = connections.Find("c1")
+			if removed := errors.Is(err, connection.ErrNotFound); removed != tt.removed {
+				t.Fatalf("expected removed %v, got %v", tt.removed, err)
+			}
+			if count, _ := p.Connections("1234"); tt.removed && count != 0 {
+				t.Fatalf("expected no counted connections, got %d", count)
+			}
+		})
+	}
+}
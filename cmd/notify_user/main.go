@@ -4,29 +4,57 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
 	"github.com/pipetail/sst-websocket/pkg/connection"
 	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
 	"go.uber.org/zap"
 )
 
+// modes of delivering the notification into the connections
+const (
+	modeQueue  = "queue"
+	modeDirect = "direct"
+)
+
 type handlerDependencies struct {
-	DynamoDB           *dynamodb.DynamoDB
-	IndexName          string
-	TableName          string
+	Connections        connection.ConnectionStore
+	Presence           presence.Store
 	Logger             *zap.Logger
-	ApiGateway         *apigatewaymanagementapi.ApiGatewayManagementApi
+	ApiGateway         apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
 	ApiGatewayEndpoint string
-	SQS                *sqs.SQS
+	SQS                sqsiface.SQSAPI
 	SQSURL             string
+
+	// ResumeURL is the notify user queue the notification is sent back
+	// to when the connections of the user can't be walked whole, it
+	// carries the cursor of the last handled connection
+	ResumeURL string
+
+	// Mode tells whether the connections are notified through
+	// the notify connection queue or directly
+	Mode string
+
+	// Concurrency limits the number of connections notified
+	// at once in the direct mode
+	Concurrency int
+
+	// DeadLetterURL receives the notifications which can't be
+	// delivered in the direct mode no matter how often they are retried
+	DeadLetterURL string
 }
 
 func main() {
@@ -55,75 +83,288 @@ func main() {
 	// create SQS client
 	sqsSvc := sqs.New(sess)
 
+	// create connection store, the users are looked up by the index
+	connections := connection.NewDynamoDB(dynamoDbSvc, table)
+	connections.UserIdIndex = index
+	connections.UserIdIndexSorted = os.Getenv("CONFIG_USER_ID_INDEX_SORTED") == "true"
+
 	// create a logger
 	logger, _ := zap.NewProduction()
 
+	// get the delivery mode, the queue is used by default
+	mode := os.Getenv("CONFIG_NOTIFY_MODE")
+	if mode == "" {
+		mode = modeQueue
+	}
+	if mode != modeQueue && mode != modeDirect {
+		logger.Fatal("unknown notify mode", zap.String("mode", mode))
+	}
+
+	// get the number of connections notified at once
+	concurrency := 1
+	if mode == modeDirect {
+		var err error
+		concurrency, err = strconv.Atoi(os.Getenv("CONFIG_NOTIFY_CONCURRENCY"))
+		if err != nil || concurrency < 1 {
+			logger.Fatal("could not parse notify concurrency", zap.Error(err))
+		}
+	}
+
 	// start the main handler
 	lambda.Start(handler(
 		handlerDependencies{
-			DynamoDB:           dynamoDbSvc,
-			IndexName:          index,
-			TableName:          table,
+			Connections:        connections,
+			Presence:           presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
 			Logger:             logger,
 			ApiGateway:         apiGatewaySvc,
 			ApiGatewayEndpoint: endpoint,
 			SQS:                sqsSvc,
 			SQSURL:             queue,
+			ResumeURL:          os.Getenv("CONFIG_SQS_NOTIFY_USER_URL"),
+			Mode:               mode,
+			Concurrency:        concurrency,
+			DeadLetterURL:      os.Getenv("CONFIG_SQS_NOTIFY_CONNECTION_DLQ_URL"),
 		},
 	))
 }
 
-func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) error {
-	return func(ctx context.Context, sqsEvent events.SQSEvent) error {
+func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+		res := events.SQSEventResponse{}
 		for _, message := range sqsEvent.Records {
-			// indicate start of the processing
-			d.Logger.Info("handling notification",
-				zap.String("payload", message.Body),
-			)
-
-			// get the notification
-			n, err := notification.UserFromString(message.Body)
+			// only the failed messages are redelivered, the rest
+			// of the batch is processed anyway
+			err := notifyUser(d, message)
 			if err != nil {
-				d.Logger.Error("could not parse notification body",
-					zap.String("payload", message.Body),
-					zap.Error(err),
-				)
-				return fmt.Errorf("could not parse notification: %s", err)
+				res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: message.MessageId,
+				})
 			}
+		}
+
+		return res, nil
+	}
+}
+
+// notifyUser sends the notification to all connections of the user
+func notifyUser(d handlerDependencies, message events.SQSMessage) error {
+	// indicate start of the processing
+	d.Logger.Info("handling notification",
+		zap.String("payload", message.Body),
+	)
 
-			// get all conections for the provided user
-			conns, err := connection.NewWithUserId(n.UserId).GetByUserId(d.DynamoDB, d.TableName, d.IndexName)
+	// get the notification
+	n, err := notification.UserFromString(message.Body)
+	if err != nil {
+		d.Logger.Error("could not parse notification body",
+			zap.String("payload", message.Body),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not parse notification: %s", err)
+	}
+
+	// the connections are notified as the pages are loaded
+	if d.Mode == modeDirect {
+		err = notifyDirect(d, n)
+	} else {
+		err = notifyQueue(d, n)
+	}
+	if err != nil {
+		d.Logger.Error("could not notify connections of the user",
+			zap.String("userId", n.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not notify connections: %s", err)
+	}
+
+	return nil
+}
+
+// notifyQueue sends a message for every connection of the user to the
+// notify connection queue
+func notifyQueue(d handlerDependencies, n notification.UserNotification) error {
+	after := connection.Cursor{}
+	if n.After != nil {
+		after = *n.After
+	}
+
+	last := after
+	err := d.Connections.EachByUserIdAfter(n.UserId, after, func(c connection.Connection) error {
+		d.Logger.Info("handling connection",
+			zap.String("userId", n.UserId),
+			zap.String("connectionId", c.ConnectionId),
+		)
+
+		err := enqueue(d, c.ConnectionId, n.Data)
+		if err != nil {
+			return err
+		}
+
+		last = c.Cursor()
+		return nil
+	})
+	if err != nil {
+		return resume(d, n, after, last, err)
+	}
+
+	return nil
+}
+
+// notifyDirect posts the notification into the connections of the user,
+// at most d.Concurrency at once, only the connections which could not
+// be handled are retried through the notify connection queue, so the
+// connections already notified don't get the notification twice
+func notifyDirect(d handlerDependencies, n notification.UserNotification) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+
+	after := connection.Cursor{}
+	if n.After != nil {
+		after = *n.After
+	}
+
+	last := after
+	sem := make(chan struct{}, d.Concurrency)
+	err := d.Connections.EachByUserIdAfter(n.UserId, after, func(c connection.Connection) error {
+		d.Logger.Info("handling connection",
+			zap.String("userId", n.UserId),
+			zap.String("connectionId", c.ConnectionId),
+		)
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(connectionId string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := post(d, connectionId, n.Data)
 			if err != nil {
-				d.Logger.Error("could get list of connections",
+				d.Logger.Warn("could not notify the connection, retrying through the queue",
+					zap.String("connectionId", connectionId),
 					zap.Error(err),
 				)
-				return fmt.Errorf("could not get connections: %s", err)
+
+				mu.Lock()
+				failed = append(failed, connectionId)
+				mu.Unlock()
 			}
+		}(c.ConnectionId)
 
-			// process all connections associated with the user and send message
-			// to the notifyconnecion lambda function
-			for _, c := range conns {
-				d.Logger.Info("handling connection",
-					zap.String("userId", n.UserId),
-					zap.String("connectionId", c.ConnectionId),
-				)
+		last = c.Cursor()
+		return nil
+	})
 
-				connectionNotitication := notification.ConnectionNotification{
-					ConnectionId: c.ConnectionId,
-					Data:         n.Data,
-				}
-
-				err = connectionNotitication.NotifySQS(d.SQS, d.SQSURL)
-				if err != nil {
-					d.Logger.Error("could not notify the connection",
-						zap.String("connectionId", c.ConnectionId),
-						zap.Error(err),
-					)
-					return err
-				}
-			}
+	// wait for the posts already started even if the paging failed
+	wg.Wait()
+
+	// the notification is lost only if it can't be even queued, all
+	// the failed connections are queued before giving up
+	qerrs := []string{}
+	for _, connectionId := range failed {
+		qerr := enqueue(d, connectionId, n.Data)
+		if qerr != nil {
+			qerrs = append(qerrs, fmt.Sprintf("%s: %s", connectionId, qerr))
+		}
+	}
+	if len(qerrs) > 0 {
+		return fmt.Errorf("could not queue %d of %d failed connections: %s", len(qerrs), len(failed), strings.Join(qerrs, "; "))
+	}
+
+	if err != nil {
+		return resume(d, n, after, last, err)
+	}
+
+	return nil
+}
+
+// resume sends the notification back to the notify user queue to continue
+// after the last handled connection when the walk failed, so the handled
+// connections are not notified again by the redelivery, the notification
+// is redelivered whole only if no connection was handled
+func resume(d handlerDependencies, n notification.UserNotification, after connection.Cursor, last connection.Cursor, cause error) error {
+	if last == after {
+		return cause
+	}
+
+	resumed := n
+	resumed.After = &last
+
+	err := resumed.NotifySQS(d.SQS, d.ResumeURL)
+	if err != nil {
+		return fmt.Errorf("could not resume notification: %s, %s", err, cause)
+	}
+
+	d.Logger.Warn("could not notify all connections of the user, resuming",
+		zap.String("userId", n.UserId),
+		zap.String("after", last.ConnectionId),
+		zap.Error(cause),
+	)
+
+	return nil
+}
+
+// post sends the notification into the connection, the closed connections
+// are forgotten and the notifications which can't be delivered at all are
+// moved to the dead-letter queue, the error means the notification should
+// be retried
+func post(d handlerDependencies, connectionId string, data string) error {
+	_, err := d.ApiGateway.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(connectionId),
+		Data:         []byte(data),
+	})
+	if err == nil {
+		return nil
+	}
+
+	switch apigw.ClassifyError(err) {
+	case apigw.ErrorGone:
+		d.Logger.Info("connection is gone, removing it",
+			zap.String("connectionId", connectionId),
+		)
+		return presence.Forget(d.Connections, d.Presence, connectionId)
+
+	case apigw.ErrorRetryable:
+		return err
+
+	default:
+		d.Logger.Error("could not notify the connection, moving to dead-letter queue",
+			zap.String("connectionId", connectionId),
+			zap.Error(err),
+		)
+
+		n := notification.ConnectionNotification{
+			ConnectionId: connectionId,
+			Data:         data,
+		}
+
+		err = n.NotifySQS(d.SQS, d.DeadLetterURL)
+		if err != nil {
+			return fmt.Errorf("could not move notification to dead-letter queue: %s", err)
 		}
 
 		return nil
 	}
 }
+
+// enqueue sends the notification of the connection to the notify
+// connection queue
+func enqueue(d handlerDependencies, connectionId string, data string) error {
+	connectionNotitication := notification.ConnectionNotification{
+		ConnectionId: connectionId,
+		Data:         data,
+	}
+
+	err := connectionNotitication.NotifySQS(d.SQS, d.SQSURL)
+	if err != nil {
+		d.Logger.Error("could not notify the connection",
+			zap.String("connectionId", connectionId),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"go.uber.org/zap"
+)
+
+const (
+	notifyQueueURL = "notify"
+	resumeURL      = "notify-user"
+	deadLetterURL  = "dead-letter"
+)
+
+// queued returns the connections notified through the queue
+func queued(t *testing.T, s *awstest.SQS, url string) []string {
+	t.Helper()
+
+	ids := []string{}
+	for _, m := range s.Messages(url) {
+		n, err := notification.ConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, n.ConnectionId)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// delivered returns the connections notified directly
+func delivered(a *awstest.ApiGateway, connectionIds ...string) []string {
+	ids := []string{}
+	for _, id := range connectionIds {
+		if len(a.Posted(id)) > 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	presence    *presence.Memory
+	sqs         *awstest.SQS
+	apiGateway  *awstest.ApiGateway
+}
+
+// newTestDependencies creates the dependencies with the connections
+// of the user already opened
+func newTestDependencies(t *testing.T, mode string, userId string, connectionIds ...string) testDependencies {
+	t.Helper()
+
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		presence:    presence.NewMemory(),
+		sqs:         awstest.NewSQS(),
+		apiGateway:  awstest.NewApiGateway(),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Connections:   td.connections,
+		Presence:      td.presence,
+		Logger:        zap.NewNop(),
+		ApiGateway:    td.apiGateway,
+		SQS:           td.sqs,
+		SQSURL:        notifyQueueURL,
+		ResumeURL:     resumeURL,
+		Mode:          mode,
+		Concurrency:   2,
+		DeadLetterURL: deadLetterURL,
+	}
+
+	storetest.Open(t, td.connections, td.presence, userId, connectionIds...)
+
+	return td
+}
+
+// failingConnections fails the walk through the connections of the user
+// after the given number of connections
+type failingConnections struct {
+	*connection.Memory
+	failAfter int
+}
+
+func (f failingConnections) EachByUserIdAfter(userId string, after connection.Cursor, fn func(c connection.Connection) error) error {
+	count := 0
+	return f.Memory.EachByUserIdAfter(userId, after, func(c connection.Connection) error {
+		if count == f.failAfter {
+			return errors.New("could not load the next page")
+		}
+		count++
+
+		return fn(c)
+	})
+}
+
+func notify(t *testing.T, d handlerDependencies, userId string) events.SQSEventResponse {
+	t.Helper()
+
+	body, err := json.Marshal(notification.UserNotification{
+		UserId: userId,
+		Data:   "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return handle(t, d, string(body))
+}
+
+// resumed handles the notifications sent back to the notify user queue
+func resumed(t *testing.T, td testDependencies) {
+	t.Helper()
+
+	messages := td.sqs.Messages(resumeURL)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 resumed notification, got %d", len(messages))
+	}
+
+	td.Connections = td.connections
+	res := handle(t, td.handlerDependencies, aws.StringValue(messages[0].MessageBody))
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+}
+
+func handle(t *testing.T, d handlerDependencies, body string) events.SQSEventResponse {
+	t.Helper()
+
+	res, err := handler(d)(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{
+			{
+				MessageId: "m1",
+				Body:      body,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestNotifyUserQueue(t *testing.T) {
+	td := newTestDependencies(t, modeQueue, "1234", "c1", "c2", "c3")
+	storetest.Open(t, td.connections, td.presence, "5678", "other")
+
+	res := notify(t, td.handlerDependencies, "1234")
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+
+	if queued := queued(t, td.sqs, notifyQueueURL); !reflect.DeepEqual(queued, []string{"c1", "c2", "c3"}) {
+		t.Fatalf("expected all connections of the user queued, got %v", queued)
+	}
+	if delivered := delivered(td.apiGateway, "c1", "c2", "c3"); len(delivered) > 0 {
+		t.Fatalf("expected no direct delivery, got %v", delivered)
+	}
+}
+
+func TestNotifyUserDirect(t *testing.T) {
+	td := newTestDependencies(t, modeDirect, "1234", "ok1", "ok2", "gone", "throttled", "forbidden")
+	td.apiGateway.Errors["gone"] = awserr.New(apigatewaymanagementapi.ErrCodeGoneException, "gone", nil)
+	td.apiGateway.Errors["throttled"] = awserr.New(apigatewaymanagementapi.ErrCodeLimitExceededException, "slow down", nil)
+	td.apiGateway.Errors["forbidden"] = awserr.New(apigatewaymanagementapi.ErrCodeForbiddenException, "forbidden", nil)
+
+	res := notify(t, td.handlerDependencies, "1234")
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+
+	if delivered := delivered(td.apiGateway, "ok1", "ok2", "gone", "throttled", "forbidden"); !reflect.DeepEqual(delivered, []string{"ok1", "ok2"}) {
+		t.Fatalf("expected delivery to ok1 and ok2, got %v", delivered)
+	}
+
+	// only the connection which failed temporarily is retried
+	if queued := queued(t, td.sqs, notifyQueueURL); !reflect.DeepEqual(queued, []string{"throttled"}) {
+		t.Fatalf("expected throttled connection queued, got %v", queued)
+	}
+
+	if queued := queued(t, td.sqs, deadLetterURL); !reflect.DeepEqual(queued, []string{"forbidden"}) {
+		t.Fatalf("expected forbidden connection dead-lettered, got %v", queued)
+	}
+
+	// the closed connection is forgotten and uncounted
+	if _, err := td.connections.Find("gone"); err != connection.ErrNotFound {
+		t.Fatalf("expected gone connection removed, got %v", err)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 4 {
+		t.Fatalf("expected 4 counted connections, got %d", count)
+	}
+}
+
+func TestNotifyUserMalformed(t *testing.T) {
+	td := newTestDependencies(t, modeDirect, "1234", "c1")
+
+	res, err := handler(td.handlerDependencies)(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{
+			{
+				MessageId: "m1",
+				Body:      "{",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.BatchItemFailures) != 1 || res.BatchItemFailures[0].ItemIdentifier != "m1" {
+		t.Fatalf("expected failure of m1, got %v", res.BatchItemFailures)
+	}
+}
+
+func TestNotifyUserQueueResume(t *testing.T) {
+	td := newTestDependencies(t, modeQueue, "1234", "c1", "c2", "c3")
+	td.Connections = failingConnections{td.connections, 1}
+
+	// the handled connections are not notified again
+	res := notify(t, td.handlerDependencies, "1234")
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+	if queued := queued(t, td.sqs, notifyQueueURL); !reflect.DeepEqual(queued, []string{"c3"}) {
+		t.Fatalf("expected the newest connection queued, got %v", queued)
+	}
+
+	resumed(t, td)
+	if queued := queued(t, td.sqs, notifyQueueURL); !reflect.DeepEqual(queued, []string{"c1", "c2", "c3"}) {
+		t.Fatalf("expected all connections queued once, got %v", queued)
+	}
+}
+
+func TestNotifyUserQueueFailure(t *testing.T) {
+	td := newTestDependencies(t, modeQueue, "1234", "c1", "c2")
+	td.sqs.Errors[notifyQueueURL] = errors.New("queue is down")
+
+	// nothing was handled, the notification is redelivered whole
+	res := notify(t, td.handlerDependencies, "1234")
+	if len(res.BatchItemFailures) != 1 {
+		t.Fatalf("expected failure of m1, got %v", res.BatchItemFailures)
+	}
+	if messages := td.sqs.Messages(resumeURL); len(messages) > 0 {
+		t.Fatalf("expected no resumed notification, got %d", len(messages))
+	}
+}
+
+func TestNotifyUserDirectResume(t *testing.T) {
+	td := newTestDependencies(t, modeDirect, "1234", "c1", "c2", "c3")
+	td.Connections = failingConnections{td.connections, 2}
+
+	res := notify(t, td.handlerDependencies, "1234")
+	if len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+	if delivered := delivered(td.apiGateway, "c1", "c2", "c3"); !reflect.DeepEqual(delivered, []string{"c2", "c3"}) {
+		t.Fatalf("expected delivery to c2 and c3, got %v", delivered)
+	}
+
+	resumed(t, td)
+	for _, id := range []string{"c1", "c2", "c3"} {
+		if posted := td.apiGateway.Posted(id); len(posted) != 1 {
+			t.Fatalf("expected %s notified once, got %d", id, len(posted))
+		}
+	}
+}
+
+func TestNotifyUserDirectQueueFailure(t *testing.T) {
+	td := newTestDependencies(t, modeDirect, "1234", "c1", "c2", "c3")
+	td.apiGateway.Errors["c1"] = awserr.New(apigatewaymanagementapi.ErrCodeLimitExceededException, "slow down", nil)
+	td.apiGateway.Errors["c2"] = awserr.New(apigatewaymanagementapi.ErrCodeLimitExceededException, "slow down", nil)
+	td.sqs.Errors[notifyQueueURL] = errors.New("queue is down")
+
+	// all the failed connections are tried
+	err := notifyDirect(td.handlerDependencies, notification.UserNotification{UserId: "1234", Data: "hello"})
+	if err == nil || !strings.Contains(err.Error(), "c1:") || !strings.Contains(err.Error(), "c2:") {
+		t.Fatalf("expected failure of c1 and c2, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/follower"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+// requiredScope has to be granted to follow presence of other users
+const requiredScope = "presence"
+
+type handlerDependencies struct {
+	Logger    *zap.Logger
+	Followers follower.Store
+}
+
+func main() {
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger:    logger,
+				Followers: follower.NewDynamoDB(dynamodb.New(sess), os.Getenv("CONFIG_FOLLOWERS_TABLE_ID")),
+			},
+		),
+	)
+}
+
+// handler lets the caller follow (POST) or unfollow (DELETE) presence
+// of the user in the path
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// get the caller verified by the authorizer
+		followerId, ok := apigw.HTTPAuthorizerString(req, apigw.AuthorizerUserIdKey)
+		if !ok || followerId == "" {
+			d.Logger.Error("missing userId in authorizer context")
+			return apigw.UnauthorizedResponse(), nil
+		}
+
+		scopes, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerScopesKey)
+		if !token.HasScopes(token.SplitScopes(scopes), requiredScope) {
+			d.Logger.Info("missing scope to follow presence",
+				zap.String("followerId", followerId),
+			)
+			return apigw.ForbiddenResponse(), nil
+		}
+
+		// get the followed user
+		userId := req.PathParameters["id"]
+		if userId == "" || userId == followerId {
+			return apigw.BadRequestResponse(), nil
+		}
+
+		var err error
+		switch req.RequestContext.HTTP.Method {
+		case http.MethodPost:
+			err = d.Followers.Follow(userId, followerId)
+		case http.MethodDelete:
+			err = d.Followers.Unfollow(userId, followerId)
+		default:
+			return apigw.BadRequestResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not update followers",
+				zap.String("userId", userId),
+				zap.String("followerId", followerId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not update followers: %s", err)
+		}
+
+		d.Logger.Info("followers updated",
+			zap.String("userId", userId),
+			zap.String("followerId", followerId),
+			zap.String("method", req.RequestContext.HTTP.Method),
+		)
+
+		// all good
+		return apigw.OkResponse(), nil
+	}
+}
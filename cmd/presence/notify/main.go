@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/follower"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger      *zap.Logger
+	Connections connection.ConnectionStore
+	Presence    presence.Store
+	Followers   follower.Store
+	SQS         sqsiface.SQSAPI
+	SQSURL      string
+}
+
+func main() {
+	// get notify user queue URL
+	queue := os.Getenv("CONFIG_SQS_NOTIFY_USER_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create SQS client
+	sqsSvc := sqs.New(sess)
+
+	// create connection store, the connections are counted using the index
+	connections := connection.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_CONNECTIONS_TABLE_ID"))
+	connections.UserIdIndex = os.Getenv("CONFIG_USER_ID_INDEX_NAME")
+	connections.UserIdIndexSorted = os.Getenv("CONFIG_USER_ID_INDEX_SORTED") == "true"
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger:      logger,
+				Connections: connections,
+				Presence:    presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
+				Followers:   follower.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_FOLLOWERS_TABLE_ID")),
+				SQS:         sqsSvc,
+				SQSURL:      queue,
+			},
+		),
+	)
+}
+
+// handler compares the settled presence of the user with the state
+// announced to the followers and notifies them about the change
+func handler(d handlerDependencies) func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+		res := events.SQSEventResponse{}
+		for _, message := range sqsEvent.Records {
+			// only the failed messages are redelivered, the rest
+			// of the batch is processed anyway
+			err := checkPresence(d, message)
+			if err != nil {
+				res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: message.MessageId,
+				})
+			}
+		}
+
+		return res, nil
+	}
+}
+
+// checkPresence notifies the followers if the presence of the user changed
+func checkPresence(d handlerDependencies, message events.SQSMessage) error {
+
+	// get the check
+	r, err := request.PresenceCheckFromString(message.Body)
+	if err != nil {
+		d.Logger.Error("could not parse presence check",
+			zap.String("payload", message.Body),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not parse presence check: %s", err)
+	}
+
+	// get the current presence
+	p, err := presence.Get(d.Connections, d.Presence, []string{r.UserId})
+	if err != nil {
+		d.Logger.Error("could not get presence",
+			zap.String("userId", r.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not get presence: %s", err)
+	}
+
+	// the connection flapped or another check already
+	// announced the change
+	published, err := d.Presence.Published(r.UserId)
+	if err != nil {
+		d.Logger.Error("could not get published presence",
+			zap.String("userId", r.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not get published presence: %s", err)
+	}
+
+	if published == p[0].Online {
+		d.Logger.Info("presence not changed",
+			zap.String("userId", r.UserId),
+			zap.Bool("online", p[0].Online),
+		)
+		return nil
+	}
+
+	d.Logger.Info("presence changed, notifying followers",
+		zap.String("userId", r.UserId),
+		zap.Bool("online", p[0].Online),
+	)
+
+	// notify the followers through the notify user queue, the change
+	// is published only once all followers were notified so the retry
+	// of the failed check notifies them again
+	frame := notification.NewPresenceFrame(r.UserId, p[0].Online, p[0].LastSeen)
+	err = d.Followers.EachFollower(r.UserId, func(followerId string) error {
+		n := notification.UserNotification{
+			UserId: followerId,
+			Data:   frame.String(),
+		}
+
+		return n.NotifySQS(d.SQS, d.SQSURL)
+	})
+	if err != nil {
+		d.Logger.Error("could not notify followers",
+			zap.String("userId", r.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not notify followers: %s", err)
+	}
+
+	// the concurrent check might have published the change already,
+	// the followers get the same presence twice at worst
+	_, err = d.Presence.Publish(r.UserId, p[0].Online)
+	if err != nil {
+		d.Logger.Error("could not publish presence",
+			zap.String("userId", r.UserId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("could not publish presence: %s", err)
+	}
+
+	return nil
+}
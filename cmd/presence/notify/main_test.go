@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/follower"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+const notifyUserQueue = "notify-user"
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	presence    *presence.Memory
+	sqs         *awstest.SQS
+}
+
+// newTestDependencies creates the dependencies with the user followed
+// by two other users
+func newTestDependencies(t *testing.T) testDependencies {
+	t.Helper()
+
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		presence:    presence.NewMemory(),
+		sqs:         awstest.NewSQS(),
+	}
+
+	followers := follower.NewMemory()
+	for _, followerId := range []string{"f1", "f2"} {
+		if err := followers.Follow("1234", followerId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:      zap.NewNop(),
+		Connections: td.connections,
+		Presence:    td.presence,
+		Followers:   followers,
+		SQS:         td.sqs,
+		SQSURL:      notifyUserQueue,
+	}
+
+	return td
+}
+
+func (td testDependencies) check(t *testing.T) events.SQSEventResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(request.PresenceCheck{UserId: "1234"})
+	res, err := handler(td.handlerDependencies)(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{{MessageId: "m1", Body: string(body)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+// notified returns the followers notified through the queue
+func (td testDependencies) notified(t *testing.T) []string {
+	t.Helper()
+
+	ids := []string{}
+	for _, m := range td.sqs.Messages(notifyUserQueue) {
+		n, err := notification.UserFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f := notification.PresenceFrame{}
+		if err := json.Unmarshal([]byte(n.Data), &f); err != nil || f.UserId != "1234" {
+			t.Fatalf("unexpected presence frame %s", n.Data)
+		}
+		ids = append(ids, n.UserId)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPresenceNotify(t *testing.T) {
+	td := newTestDependencies(t)
+	storetest.Open(t, td.connections, td.presence, "1234", "c1")
+
+	if res := td.check(t); len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+	if notified := td.notified(t); len(notified) != 2 || notified[0] != "f1" || notified[1] != "f2" {
+		t.Fatalf("expected f1 and f2 notified, got %v", notified)
+	}
+	if published, _ := td.presence.Published("1234"); !published {
+		t.Fatal("expected online presence published")
+	}
+
+	// the settled presence is announced once
+	_ = td.check(t)
+	if notified := td.notified(t); len(notified) != 2 {
+		t.Fatalf("expected no other notification, got %v", notified)
+	}
+}
+
+func TestPresenceNotifyFlapped(t *testing.T) {
+	td := newTestDependencies(t)
+
+	// the user connected and disconnected before the check
+	if res := td.check(t); len(res.BatchItemFailures) > 0 {
+		t.Fatalf("unexpected failures %v", res.BatchItemFailures)
+	}
+	if notified := td.notified(t); len(notified) > 0 {
+		t.Fatalf("expected no notification, got %v", notified)
+	}
+}
+
+func TestPresenceNotifyFailure(t *testing.T) {
+	td := newTestDependencies(t)
+	storetest.Open(t, td.connections, td.presence, "1234", "c1")
+	td.sqs.Errors[notifyUserQueue] = errors.New("queue is down")
+
+	// the change is not published so the retry notifies the followers
+	if res := td.check(t); len(res.BatchItemFailures) != 1 {
+		t.Fatalf("expected failure of m1, got %v", res.BatchItemFailures)
+	}
+	if published, _ := td.presence.Published("1234"); published {
+		t.Fatal("expected presence not published")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger *zap.Logger
+	SQS    *sqs.SQS
+	SQSURL string
+
+	// Debounce is how long the presence has to settle before
+	// the followers are notified
+	Debounce time.Duration
+}
+
+func main() {
+	// get presence check queue URL
+	queue := os.Getenv("CONFIG_SQS_PRESENCE_CHECK_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create SQS client
+	sqsSvc := sqs.New(sess)
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// get debounce period in seconds
+	debounce, err := strconv.Atoi(os.Getenv("CONFIG_PRESENCE_DEBOUNCE"))
+	if err != nil {
+		logger.Fatal("could not parse presence debounce", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger:   logger,
+				SQS:      sqsSvc,
+				SQSURL:   queue,
+				Debounce: time.Duration(debounce) * time.Second,
+			},
+		),
+	)
+}
+
+// handler consumes the stream of the connections table, the users whose
+// connection was opened, authorized or closed are checked once their
+// presence settles
+func handler(d handlerDependencies) func(ctx context.Context, e events.DynamoDBEvent) error {
+	return func(ctx context.Context, e events.DynamoDBEvent) error {
+
+		// the batch often contains several changes of the same user
+		users := []string{}
+		seen := map[string]bool{}
+		for _, record := range e.Records {
+			oldUserId := userId(record.Change.OldImage)
+			newUserId := userId(record.Change.NewImage)
+
+			// only the changes of the user of the connection matter, the
+			// anonymous connection gets the user when it's authorized
+			if oldUserId == newUserId {
+				continue
+			}
+
+			for _, u := range []string{oldUserId, newUserId} {
+				if u != "" && !seen[u] {
+					seen[u] = true
+					users = append(users, u)
+				}
+			}
+		}
+
+		for _, u := range users {
+			d.Logger.Info("scheduling presence check",
+				zap.String("userId", u),
+				zap.Duration("debounce", d.Debounce),
+			)
+
+			err := request.PresenceCheck{UserId: u}.CheckDelayedSQS(d.SQS, d.SQSURL, d.Debounce)
+			if err != nil {
+				d.Logger.Error("could not schedule presence check",
+					zap.String("userId", u),
+					zap.Error(err),
+				)
+				return fmt.Errorf("could not schedule presence check: %s", err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// userId returns the user of the connection in the stream image
+func userId(image map[string]events.DynamoDBAttributeValue) string {
+	v, ok := image["UserId"]
+	if !ok || v.DataType() != events.DataTypeString {
+		return ""
+	}
+
+	return v.String()
+}
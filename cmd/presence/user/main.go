@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+// requiredScope has to be granted to look up presence of other users
+const requiredScope = "presence"
+
+type handlerDependencies struct {
+	Logger      *zap.Logger
+	Connections connection.ConnectionStore
+	Presence    presence.Store
+}
+
+func main() {
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create connection store, the connections are counted using the index
+	connections := connection.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_CONNECTIONS_TABLE_ID"))
+	connections.UserIdIndex = os.Getenv("CONFIG_USER_ID_INDEX_NAME")
+	connections.UserIdIndexSorted = os.Getenv("CONFIG_USER_ID_INDEX_SORTED") == "true"
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger:      logger,
+				Connections: connections,
+				Presence:    presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// get the user to look up
+		userId := req.PathParameters["id"]
+		if userId == "" {
+			return apigw.BadRequestResponse(), nil
+		}
+
+		// users can see their own presence, others need the scope
+		callerId, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerUserIdKey)
+		scopes, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerScopesKey)
+		if callerId != userId && !token.HasScopes(token.SplitScopes(scopes), requiredScope) {
+			d.Logger.Info("missing scope to look up presence",
+				zap.String("callerId", callerId),
+				zap.String("userId", userId),
+			)
+			return apigw.ForbiddenResponse(), nil
+		}
+
+		// get the presence
+		res, err := presence.Get(d.Connections, d.Presence, []string{userId})
+		if err != nil {
+			d.Logger.Error("could not get presence",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not get presence: %s", err)
+		}
+
+		// all good
+		return apigw.JSONResponse(http.StatusOK, res[0])
+	}
+}
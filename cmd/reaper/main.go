@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger      *zap.Logger
+	Connections connection.ConnectionStore
+	SQS         sqsiface.SQSAPI
+	SQSURL      string
+
+	// IdleTimeout is how long the connection can stay open
+	// without receiving any message
+	IdleTimeout time.Duration
+}
+
+func main() {
+	// get dynamodb table name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+
+	// get delete connection queue URL
+	queue := os.Getenv("CONFIG_SQS_DELETE_CONNECTION_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// get idle timeout in seconds
+	idleTimeout, err := strconv.Atoi(os.Getenv("CONFIG_IDLE_TIMEOUT"))
+	if err != nil {
+		logger.Fatal("could not parse idle timeout", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger:      logger,
+				Connections: connection.NewDynamoDB(dynamodb.New(sess), table),
+				SQS:         sqs.New(sess),
+				SQSURL:      queue,
+				IdleTimeout: time.Duration(idleTimeout) * time.Second,
+			},
+		),
+	)
+}
+
+// handler is run periodically and requests closing of the connections
+// idle for longer than the timeout, the clients are notified about the
+// reason by cmd/delete_connection
+func handler(d handlerDependencies) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := time.Now()
+		before := now.Add(-d.IdleTimeout).Unix()
+
+		d.Logger.Info("reaping idle connections",
+			zap.Int64("lastSeenBefore", before),
+		)
+
+		count := 0
+		err := d.Connections.EachIdle(before, func(c connection.Connection) error {
+			d.Logger.Info("closing idle connection",
+				zap.String("connectionId", c.ConnectionId),
+				zap.String("userId", c.UserId),
+				zap.Int64("lastSeen", c.LastSeen),
+			)
+
+			r := request.DeleteConnectionFromId(c.ConnectionId)
+			r.Reason = request.ReasonIdle
+
+			err := r.DeleteSQS(d.SQS, d.SQSURL)
+			if err != nil {
+				return fmt.Errorf("could not request deletion of connection %s: %s", c.ConnectionId, err)
+			}
+
+			// the connection is skipped by the next runs while the
+			// deletion is pending, the connection closed meanwhile
+			// doesn't need the mark
+			err = d.Connections.MarkClosing(c.ConnectionId, now.Unix())
+			if err != nil && !errors.Is(err, connection.ErrNotFound) {
+				return fmt.Errorf("could not mark connection %s as closing: %s", c.ConnectionId, err)
+			}
+
+			count++
+			return nil
+		})
+		if err != nil {
+			d.Logger.Error("could not reap idle connections",
+				zap.Int("closed", count),
+				zap.Error(err),
+			)
+			return err
+		}
+
+		d.Logger.Info("idle connections reaped",
+			zap.Int("closed", count),
+		)
+
+		// all good
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+const deleteQueue = "delete"
+
+// closed returns the connections requested to be closed as idle
+func closed(t *testing.T, s *awstest.SQS) []string {
+	t.Helper()
+
+	ids := []string{}
+	for _, m := range s.Messages(deleteQueue) {
+		r, err := request.DeleteConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Reason != request.ReasonIdle {
+			t.Fatalf("expected reason %s, got %s", request.ReasonIdle, r.Reason)
+		}
+		ids = append(ids, r.ConnectionId)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func TestReaper(t *testing.T) {
+	connections := connection.NewMemory()
+	s := awstest.NewSQS()
+	d := handlerDependencies{
+		Logger:      zap.NewNop(),
+		Connections: connections,
+		SQS:         s,
+		SQSURL:      deleteQueue,
+		IdleTimeout: 30 * time.Minute,
+	}
+
+	now := time.Now()
+	storetest.Open(t, connections, nil, "1234", "active", "idle1", "idle2", "evicted")
+	for _, id := range []string{"idle1", "idle2", "evicted"} {
+		_ = connections.Touch(id, now.Add(-time.Hour).Unix())
+	}
+
+	// the evicted connection is being closed already
+	if err := connections.Evict("evicted"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler(d)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ids := closed(t, s); !reflect.DeepEqual(ids, []string{"idle1", "idle2"}) {
+		t.Fatalf("expected idle connections closed, got %v", ids)
+	}
+
+	// the pending deletions are not requested again
+	if err := handler(d)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ids := closed(t, s); len(ids) != 2 {
+		t.Fatalf("expected no new deletions, got %v", ids)
+	}
+}
+
+func TestReaperQueueFailure(t *testing.T) {
+	connections := connection.NewMemory()
+	s := awstest.NewSQS()
+	s.Errors[deleteQueue] = errors.New("unavailable")
+	d := handlerDependencies{
+		Logger:      zap.NewNop(),
+		Connections: connections,
+		SQS:         s,
+		SQSURL:      deleteQueue,
+		IdleTimeout: 30 * time.Minute,
+	}
+
+	storetest.Open(t, connections, nil, "1234", "idle")
+	_ = connections.Touch("idle", time.Now().Add(-time.Hour).Unix())
+
+	if err := handler(d)(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// the connection is not marked so the next run retries it
+	if found, _ := connections.Find("idle"); found.Closing != 0 {
+		t.Fatalf("expected connection not marked, got %+v", found)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/guard"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger              *zap.Logger
+	Validator           token.Validator
+	Connections         connection.ConnectionStore
+	SQS                 sqsiface.SQSAPI
+	SQSURL              string
+	DeleteConnectionURL string
+
+	// Guard replies to the client through the notify connection queue
+	Guard guard.Dependencies
+}
+
+// reauthMessage is sent by the client before its token expires
+type reauthMessage struct {
+	Token string `json:"token"`
+}
+
+func main() {
+	// get dynamodb table name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+
+	// get notify connection queue URL
+	queue := os.Getenv("CONFIG_SQS_NOTIFY_CONNECTION_URL")
+
+	// get delete connection queue URL
+	deleteQueue := os.Getenv("CONFIG_SQS_DELETE_CONNECTION_URL")
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create SQS client
+	sqsSvc := sqs.New(sess)
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	d := handlerDependencies{
+		Logger: logger,
+		Validator: token.Validator{
+			Keys:        token.NewJWKSSource(os.Getenv("CONFIG_JWKS_URL"), keystore.CacheTTL),
+			Audience:    os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+			Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+		},
+		Connections:         connection.NewDynamoDB(dynamoDbSvc, table),
+		SQS:                 sqsSvc,
+		SQSURL:              queue,
+		DeleteConnectionURL: deleteQueue,
+	}
+
+	d.Guard = guard.Dependencies{
+		Logger:      d.Logger,
+		Connections: d.Connections,
+		SQS:         d.SQS,
+		SQSURL:      d.SQSURL,
+	}
+
+	// start the main handler, only the connections which are already
+	// authorized can re-authenticate, the others use the authorize action
+	lambda.Start(
+		guard.Authorized(d.Guard,
+			handler(d),
+		),
+	)
+}
+
+func handler(d handlerDependencies) apigw.WebsocketHandler {
+	return func(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
+
+		// get the connection id
+		connectionId := req.RequestContext.ConnectionID
+
+		// get the connection loaded by the guard
+		current, ok := guard.ConnectionFromContext(ctx)
+		if !ok {
+			return apigw.InternalServerErrorResponse(), errors.New("connection is not in the context, the handler is not wrapped by Authorized")
+		}
+
+		// log the attempt
+		d.Logger.Info("re-authenticating connection",
+			zap.String("connectionId", connectionId),
+			zap.String("userId", current.UserId),
+		)
+
+		// get the token from the message
+		m := reauthMessage{}
+		err := json.Unmarshal([]byte(req.Body), &m)
+		if err != nil || m.Token == "" {
+			d.Logger.Info("could not parse reauth message",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return guard.Reply(d.Guard, connectionId, notification.ErrorFrame("bad_request", "token is missing"), apigw.BadRequestResponse())
+		}
+
+		// verify the token, the current session is kept until
+		// it expires if the new token is not valid
+		claims, err := d.Validator.Validate(m.Token)
+		if err != nil {
+			d.Logger.Info("invalid token",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return guard.Reply(d.Guard, connectionId, notification.ErrorFrame("unauthorized", "invalid token"), apigw.UnauthorizedResponse())
+		}
+
+		// the connection can't switch users, it's closed
+		// right away as it's hard to tell who is on the other side
+		if claims.UserId != current.UserId {
+			d.Logger.Info("token belongs to another user, closing connection",
+				zap.String("connectionId", connectionId),
+				zap.String("userId", current.UserId),
+				zap.String("tokenUserId", claims.UserId),
+			)
+
+			res, err := guard.Reply(d.Guard, connectionId, notification.ErrorFrame("forbidden", "token belongs to another user"), apigw.ForbiddenResponse())
+			if err != nil {
+				return res, err
+			}
+
+			r := request.DeleteConnectionFromId(connectionId)
+			r.Reason = request.ReasonUserMismatch
+
+			err = r.DeleteSQS(d.SQS, d.DeleteConnectionURL)
+			if err != nil {
+				d.Logger.Error("could not request deletion of connection",
+					zap.String("connectionId", connectionId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), err
+			}
+
+			return res, nil
+		}
+
+		// swap the principal, the scopes and the expiration
+		c := connection.New(connectionId, claims.UserId)
+		c.TokenId = claims.Family()
+		c.Principal = claims.UserId
+		c.Claims = claims.Map()
+		c.Scopes = claims.Scopes()
+		c.SessionExpiresAt = claims.ExpiresAt
+
+		err = d.Connections.Authorize(c)
+		if errors.Is(err, connection.ErrNotFound) {
+			d.Logger.Info("connection is gone",
+				zap.String("connectionId", connectionId),
+			)
+			return apigw.OkResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not re-authenticate connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		// reschedule the deletion, the one scheduled for the
+		// previous expiration is ignored by cmd/delete_connection
+		err = request.DeleteConnectionAt(connectionId, c.SessionExpiresAt).DeleteDelayedSQS(d.SQS, d.DeleteConnectionURL)
+		if err != nil {
+			d.Logger.Error("could not schedule deletion of connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		d.Logger.Info("connection re-authenticated",
+			zap.String("connectionId", connectionId),
+			zap.String("userId", claims.UserId),
+			zap.String("previousJti", current.TokenId),
+			zap.String("jti", claims.Id),
+			zap.Int64("sessionExpiresAt", c.SessionExpiresAt),
+		)
+
+		// all good
+		return guard.Reply(d.Guard, connectionId, notification.Frame{Type: "reauthenticated"}, apigw.OkResponse())
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/internal/tokentest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/guard"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+const (
+	notifyQueue = "notify"
+	deleteQueue = "delete"
+)
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	sqs         *awstest.SQS
+	issuer      token.Issuer
+}
+
+func newTestDependencies(t *testing.T) testDependencies {
+	t.Helper()
+
+	keys := tokentest.Keys(t)
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		sqs:         awstest.NewSQS(),
+		issuer:      tokentest.Issuer(keys),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:              zap.NewNop(),
+		Validator:           tokentest.Validator(keys, nil),
+		Connections:         td.connections,
+		SQS:                 td.sqs,
+		SQSURL:              notifyQueue,
+		DeleteConnectionURL: deleteQueue,
+		Guard: guard.Dependencies{
+			Logger:      zap.NewNop(),
+			Connections: td.connections,
+			SQS:         td.sqs,
+			SQSURL:      notifyQueue,
+		},
+	}
+
+	storetest.Open(t, td.connections, nil, "1234", "c1")
+	return td
+}
+
+// reauth sends the token of the user through the guard the handler is wrapped by
+func (td testDependencies) reauth(t *testing.T, userId string) (int, token.Claims) {
+	t.Helper()
+
+	tok, claims, err := td.issuer.Issue(userId, []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(reauthMessage{Token: tok})
+	res, err := guard.Authorized(td.Guard, handler(td.handlerDependencies))(context.Background(), &events.APIGatewayWebsocketProxyRequest{
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: "c1",
+		},
+		Body: string(body),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, claims
+}
+
+// deletions returns the deletions of the connection requested through the queue
+func (td testDependencies) deletions(t *testing.T) []request.DeleteConnection {
+	t.Helper()
+
+	deletions := []request.DeleteConnection{}
+	for _, m := range td.sqs.Messages(deleteQueue) {
+		r, err := request.DeleteConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deletions = append(deletions, r)
+	}
+
+	return deletions
+}
+
+func TestReauth(t *testing.T) {
+	td := newTestDependencies(t)
+
+	status, claims := td.reauth(t, "1234")
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	// the session lasts as long as the new token
+	c, err := td.connections.Get("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SessionExpiresAt != claims.ExpiresAt || c.TokenId != claims.Family() {
+		t.Fatalf("expected the session of the new token, got %+v", c)
+	}
+
+	deletions := td.deletions(t)
+	if len(deletions) != 1 || deletions[0].ExpiresAt != claims.ExpiresAt {
+		t.Fatalf("expected deletion scheduled at %d, got %+v", claims.ExpiresAt, deletions)
+	}
+}
+
+func TestReauthAnotherUser(t *testing.T) {
+	td := newTestDependencies(t)
+	before, _ := td.connections.Get("c1")
+
+	status, _ := td.reauth(t, "5678")
+	if status != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, status)
+	}
+
+	// the connection is closed and keeps its session until then
+	deletions := td.deletions(t)
+	if len(deletions) != 1 || deletions[0].Reason != request.ReasonUserMismatch {
+		t.Fatalf("expected deletion of the connection, got %+v", deletions)
+	}
+	if c, _ := td.connections.Get("c1"); c.UserId != "1234" || c.SessionExpiresAt != before.SessionExpiresAt {
+		t.Fatalf("expected the session to be kept, got %+v", c)
+	}
+}
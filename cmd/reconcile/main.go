@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/checkpoint"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"go.uber.org/zap"
+)
+
+// deadlineMargin is left for reporting before the function times out
+const deadlineMargin = 10 * time.Second
+
+// minAge protects the connections still inside $connect, API Gateway
+// reports them as gone until the handler returns
+const minAge = time.Minute
+
+// checkpointName is the name of the position where the previous run stopped
+const checkpointName = "reconcile"
+
+// errDeadline stops the walk when the function is about to time out
+var errDeadline = errors.New("function is about to time out")
+
+type handlerDependencies struct {
+	Logger             *zap.Logger
+	ApiGateway         apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+	ApiGatewayEndpoint string
+	Connections        connection.ConnectionStore
+	Presence           presence.Store
+
+	// Checkpoints keep the position where the previous run stopped,
+	// the table is too big to be walked in a single run
+	Checkpoints checkpoint.Store
+
+	// DryRun only reports the ghosts without deleting them
+	DryRun bool
+
+	// Rate is the maximum of connections checked per second, it's also
+	// used as the page size of the table scan
+	Rate int
+}
+
+// reconcileEvent can override the dry run mode when invoked manually
+type reconcileEvent struct {
+	DryRun *bool `json:"dryRun"`
+}
+
+// report summarizes the run
+type report struct {
+	DryRun   bool `json:"dryRun"`
+	Scanned  int  `json:"scanned"`
+	Live     int  `json:"live"`
+	Ghosts   int  `json:"ghosts"`
+	Deleted  int  `json:"deleted"`
+	Skipped  int  `json:"skipped"`
+	Failed   int  `json:"failed"`
+	Complete bool `json:"complete"`
+}
+
+func main() {
+	// get API Gateway endpoint
+	endpoint := apigw.SanitizeURL(os.Getenv("CONFIG_API_GATEWAY_ENDPOINT"))
+
+	// create apigateway client
+	apiGatewaySess, _ := session.NewSession(&aws.Config{
+		Endpoint: aws.String(endpoint),
+	})
+	apiGatewaySvc := apigatewaymanagementapi.New(apiGatewaySess)
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// get the throughput limit
+	rate, err := strconv.Atoi(os.Getenv("CONFIG_RECONCILE_RATE"))
+	if err != nil || rate <= 0 {
+		logger.Fatal("could not parse reconcile rate", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(handler(
+		handlerDependencies{
+			Logger:             logger,
+			ApiGateway:         apiGatewaySvc,
+			ApiGatewayEndpoint: endpoint,
+			Connections:        connection.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")),
+			Presence:           presence.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_PRESENCE_TABLE_ID")),
+			Checkpoints:        checkpoint.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_CHECKPOINTS_TABLE_ID")),
+			DryRun:             os.Getenv("CONFIG_RECONCILE_DRY_RUN") == "true",
+			Rate:               rate,
+		},
+	))
+}
+
+// handler walks the connections table and deletes the records of the
+// connections API Gateway reports as gone, they leak whenever $disconnect
+// fails or is not delivered
+func handler(d handlerDependencies) func(ctx context.Context, e reconcileEvent) (report, error) {
+	return func(ctx context.Context, e reconcileEvent) (report, error) {
+		r := report{
+			DryRun: d.DryRun,
+		}
+		if e.DryRun != nil {
+			r.DryRun = *e.DryRun
+		}
+
+		// continue where the previous run stopped, the dry runs
+		// walk from the beginning and leave the position alone
+		after := ""
+		if !r.DryRun {
+			var err error
+			after, err = d.Checkpoints.Load(checkpointName)
+			if err != nil {
+				d.Logger.Error("could not load checkpoint",
+					zap.Error(err),
+				)
+				return r, fmt.Errorf("could not load checkpoint: %s", err)
+			}
+		}
+
+		d.Logger.Info("reconciling connections",
+			zap.Bool("dryRun", r.DryRun),
+			zap.Int("rate", d.Rate),
+			zap.String("after", after),
+		)
+
+		// the connections are checked at most Rate per second
+		ticker := time.NewTicker(time.Second / time.Duration(d.Rate))
+		defer ticker.Stop()
+
+		deadline, hasDeadline := ctx.Deadline()
+
+		last := after
+		err := d.Connections.Each(after, d.Rate, func(c connection.Connection) error {
+			if hasDeadline && time.Until(deadline) < deadlineMargin {
+				return errDeadline
+			}
+
+			<-ticker.C
+			r.Scanned++
+
+			err := reconcile(d, &r, c)
+			if err != nil {
+				return err
+			}
+
+			last = c.ConnectionId
+			return nil
+		})
+		r.Complete = err == nil
+
+		// the next run starts over once the whole table was walked
+		if !r.DryRun {
+			if r.Complete {
+				last = ""
+			}
+
+			cerr := d.Checkpoints.Save(checkpointName, last)
+			if cerr != nil {
+				d.Logger.Error("could not save checkpoint",
+					zap.String("after", last),
+					zap.Error(cerr),
+				)
+			}
+		}
+
+		if err != nil && !errors.Is(err, errDeadline) {
+			d.Logger.Error("could not reconcile connections",
+				zap.Any("report", r),
+				zap.Error(err),
+			)
+			return r, err
+		}
+
+		d.Logger.Info("connections reconciled",
+			zap.Bool("dryRun", r.DryRun),
+			zap.Int("scanned", r.Scanned),
+			zap.Int("live", r.Live),
+			zap.Int("ghosts", r.Ghosts),
+			zap.Int("deleted", r.Deleted),
+			zap.Int("skipped", r.Skipped),
+			zap.Int("failed", r.Failed),
+			zap.Bool("complete", r.Complete),
+		)
+
+		// all good
+		return r, nil
+	}
+}
+
+// reconcile probes the connection and deletes its record if API Gateway
+// reports it as gone, the connections failing with the transient errors
+// are left for the next run
+func reconcile(d handlerDependencies, r *report, c connection.Connection) error {
+	if time.Since(c.Created) < minAge {
+		r.Skipped++
+		return nil
+	}
+
+	// probe the connection
+	_, err := d.ApiGateway.GetConnection(&apigatewaymanagementapi.GetConnectionInput{
+		ConnectionId: aws.String(c.ConnectionId),
+	})
+
+	switch {
+	case err == nil:
+		r.Live++
+		return nil
+
+	case apigw.ClassifyError(err) == apigw.ErrorRetryable:
+		r.Failed++
+		d.Logger.Warn("could not get connection, skipping",
+			zap.String("connectionId", c.ConnectionId),
+			zap.Error(err),
+		)
+		return nil
+
+	case apigw.ClassifyError(err) != apigw.ErrorGone:
+		return fmt.Errorf("could not get connection %s: %s", c.ConnectionId, err)
+	}
+
+	r.Ghosts++
+	d.Logger.Info("ghost connection found",
+		zap.String("connectionId", c.ConnectionId),
+		zap.String("userId", c.UserId),
+		zap.Time("created", c.Created),
+		zap.Bool("dryRun", r.DryRun),
+	)
+
+	if r.DryRun {
+		return nil
+	}
+
+	err = presence.Forget(d.Connections, d.Presence, c.ConnectionId)
+	if err != nil {
+		return fmt.Errorf("could not forget connection %s: %s", c.ConnectionId, err)
+	}
+
+	r.Deleted++
+	return nil
+}
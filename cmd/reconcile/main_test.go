@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/checkpoint"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/presence"
+	"go.uber.org/zap"
+)
+
+// agedConnections reports the connections as created before minAge so
+// they aren't skipped as still connecting
+type agedConnections struct {
+	*connection.Memory
+}
+
+func (a agedConnections) Each(after string, pageSize int, fn func(c connection.Connection) error) error {
+	return a.Memory.Each(after, pageSize, func(c connection.Connection) error {
+		c.Created = c.Created.Add(-minAge)
+		return fn(c)
+	})
+}
+
+type testDependencies struct {
+	handlerDependencies
+	connections *connection.Memory
+	presence    *presence.Memory
+	checkpoints *checkpoint.Memory
+	apiGateway  *awstest.ApiGateway
+}
+
+// newTestDependencies creates the records of the connections, only the
+// live ones are reported by API Gateway
+func newTestDependencies(t *testing.T, connectionIds []string, live ...string) testDependencies {
+	t.Helper()
+
+	td := testDependencies{
+		connections: connection.NewMemory(),
+		presence:    presence.NewMemory(),
+		checkpoints: checkpoint.NewMemory(),
+		apiGateway:  awstest.NewApiGateway(),
+	}
+
+	td.handlerDependencies = handlerDependencies{
+		Logger:      zap.NewNop(),
+		ApiGateway:  td.apiGateway,
+		Connections: agedConnections{td.connections},
+		Presence:    td.presence,
+		Checkpoints: td.checkpoints,
+		Rate:        1000,
+	}
+
+	storetest.Open(t, td.connections, td.presence, "1234", connectionIds...)
+
+	for _, connectionId := range live {
+		td.apiGateway.Live[connectionId] = &apigatewaymanagementapi.GetConnectionOutput{}
+	}
+
+	return td
+}
+
+func (td testDependencies) reconcile(t *testing.T, ctx context.Context, e reconcileEvent) report {
+	t.Helper()
+
+	r, err := handler(td.handlerDependencies)(ctx, e)
+	if err != nil {
+		t.Logf("reconcile failed: %s", err)
+	}
+
+	return r
+}
+
+func (td testDependencies) checkpoint(t *testing.T) string {
+	t.Helper()
+
+	after, err := td.checkpoints.Load(checkpointName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return after
+}
+
+func TestReconcile(t *testing.T) {
+	td := newTestDependencies(t, []string{"c1", "c2", "c3"}, "c2")
+	if err := td.checkpoints.Save(checkpointName, "c0"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := td.reconcile(t, context.Background(), reconcileEvent{})
+	if !r.Complete || r.Scanned != 3 || r.Live != 1 || r.Ghosts != 2 || r.Deleted != 2 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+
+	// the ghosts are forgotten
+	if _, err := td.connections.Find("c1"); err != connection.ErrNotFound {
+		t.Fatalf("expected c1 to be deleted, got %v", err)
+	}
+	if count, _ := td.presence.Connections("1234"); count != 1 {
+		t.Fatalf("expected 1 counted connection, got %d", count)
+	}
+
+	// the next run starts over
+	if after := td.checkpoint(t); after != "" {
+		t.Fatalf("expected checkpoint to be reset, got %q", after)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	td := newTestDependencies(t, []string{"c1", "c2"}, "c2")
+	if err := td.checkpoints.Save(checkpointName, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dry run walks the whole table and keeps the position
+	dryRun := true
+	r := td.reconcile(t, context.Background(), reconcileEvent{DryRun: &dryRun})
+	if !r.DryRun || r.Scanned != 2 || r.Ghosts != 1 || r.Deleted != 0 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if _, err := td.connections.Find("c1"); err != nil {
+		t.Fatalf("expected c1 to be kept, got %v", err)
+	}
+	if after := td.checkpoint(t); after != "c1" {
+		t.Fatalf("expected checkpoint c1, got %q", after)
+	}
+}
+
+func TestReconcileCheckpoint(t *testing.T) {
+	td := newTestDependencies(t, []string{"c1", "c2", "c3"}, "c1", "c2", "c3")
+	td.apiGateway.Errors["c2"] = awserr.New(apigatewaymanagementapi.ErrCodeForbiddenException, "forbidden", nil)
+
+	// the run stops at the failing connection
+	r := td.reconcile(t, context.Background(), reconcileEvent{})
+	if r.Complete || r.Scanned != 2 || r.Live != 1 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if after := td.checkpoint(t); after != "c1" {
+		t.Fatalf("expected checkpoint c1, got %q", after)
+	}
+
+	// the next run continues after the last checked connection
+	delete(td.apiGateway.Errors, "c2")
+	r = td.reconcile(t, context.Background(), reconcileEvent{})
+	if !r.Complete || r.Scanned != 2 || r.Live != 2 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+}
+
+func TestReconcileDeadline(t *testing.T) {
+	td := newTestDependencies(t, []string{"c1", "c2"})
+	if err := td.checkpoints.Save(checkpointName, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the function is about to time out, the position is kept
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin-time.Second)
+	defer cancel()
+
+	r, err := handler(td.handlerDependencies)(ctx, reconcileEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Complete || r.Scanned != 0 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if after := td.checkpoint(t); after != "c1" {
+		t.Fatalf("expected checkpoint c1, got %q", after)
+	}
+}
@@ -1,17 +1,145 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/credentials"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
 )
 
-func Handler(request events.APIGatewayV2HTTPRequest) (events.APIGatewayProxyResponse, error) {
-	return events.APIGatewayProxyResponse{
-		Body:       "Hello, World! Your request was received at " + request.RequestContext.Time + ".",
-		StatusCode: 200,
-	}, nil
+type handlerDependencies struct {
+	Logger        *zap.Logger
+	Issuer        token.Issuer
+	Credentials   credentials.Verifier
+	DefaultScopes []string
+}
+
+type tokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
 }
 
 func main() {
-	lambda.Start(Handler)
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// load keys from the key store, the keys are cached so the
+	// store is not hit on every request
+	keys := token.NewCachedKeySource(
+		keystore.KeySource{
+			Store: keystore.NewDynamoDB(dynamodb.New(sess), os.Getenv("CONFIG_KEYS_TABLE_ID")),
+		},
+		keystore.CacheTTL,
+	)
+
+	// get token lifetime in seconds
+	ttl, err := strconv.Atoi(os.Getenv("CONFIG_TOKEN_TTL"))
+	if err != nil {
+		logger.Fatal("could not parse token TTL", zap.Error(err))
+	}
+
+	// get credentials of users allowed to obtain the token
+	verifier, err := credentials.NewStaticFromString(os.Getenv("CONFIG_CREDENTIALS"))
+	if err != nil {
+		logger.Fatal("could not load credentials", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Issuer: token.Issuer{
+					Keys:     keys,
+					Audience: os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+					TTL:      time.Duration(ttl) * time.Second,
+				},
+				Credentials:   verifier,
+				DefaultScopes: token.SplitScopes(os.Getenv("CONFIG_TOKEN_DEFAULT_SCOPES")),
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// get the credentials
+		body, err := apigw.HTTPRequestBody(req)
+		if err != nil {
+			d.Logger.Error("could not decode request body",
+				zap.Error(err),
+			)
+			return apigw.BadRequestResponse(), nil
+		}
+
+		c, err := credentials.FromString(body)
+		if err != nil || c.UserId == "" {
+			d.Logger.Error("could not parse credentials",
+				zap.Error(err),
+			)
+			return apigw.BadRequestResponse(), nil
+		}
+
+		// check the credentials
+		scopes, err := d.Credentials.Verify(ctx, c)
+		if errors.Is(err, credentials.ErrInvalidCredentials) {
+			d.Logger.Info("invalid credentials",
+				zap.String("userId", c.UserId),
+			)
+			return apigw.UnauthorizedResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not verify credentials",
+				zap.String("userId", c.UserId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not verify credentials: %s", err)
+		}
+
+		// users without explicitly granted scopes get the default ones
+		if len(scopes) == 0 {
+			scopes = d.DefaultScopes
+		}
+
+		// issue the token
+		t, claims, err := d.Issuer.Issue(c.UserId, scopes)
+		if err != nil {
+			d.Logger.Error("could not issue token",
+				zap.String("userId", c.UserId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not issue token: %s", err)
+		}
+
+		d.Logger.Info("token issued",
+			zap.String("userId", claims.UserId),
+			zap.String("jti", claims.Id),
+			zap.String("scope", claims.Scope),
+		)
+
+		// all good
+		return apigw.JSONResponse(http.StatusOK, tokenResponse{
+			Token:     t,
+			ExpiresAt: claims.ExpiresAt,
+		})
+	}
 }
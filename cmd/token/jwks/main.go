@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger *zap.Logger
+	Keys   token.KeySource
+}
+
+func main() {
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Keys: token.NewCachedKeySource(
+					keystore.KeySource{
+						Store: keystore.NewDynamoDB(dynamodb.New(sess), os.Getenv("CONFIG_KEYS_TABLE_ID")),
+					},
+					keystore.CacheTTL,
+				),
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(_ context.Context, _ events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, _ events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// load all keys, only the public ones are published
+		keys, err := d.Keys.Keys()
+		if err != nil {
+			d.Logger.Error("could not load keys",
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not load keys: %s", err)
+		}
+
+		res, err := apigw.JSONResponse(http.StatusOK, keys.JWKS())
+		if err != nil {
+			return res, err
+		}
+
+		// let the verifiers cache the set as long as we do
+		res.Headers["Cache-Control"] = fmt.Sprintf("public, max-age=%d", int(keystore.CacheTTL.Seconds()))
+
+		// all good
+		return res, nil
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/token"
+)
+
+const usage = `usage: keys [-file path | -table name] <command> [arguments]
+
+commands:
+  list               list all keys
+  generate [alg]     generate a new key (RS256 by default) as the next key
+  promote <id>       make the key the signing key, wait at least %s
+                     after the key was generated so the verifiers know it
+  retire <id>        remove the key, tokens signed by it are rejected
+`
+
+func main() {
+	file := flag.String("file", "", "path to the local key file")
+	table := flag.String("table", "", "name of the DynamoDB keys table")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, keystore.CacheTTL)
+	}
+	flag.Parse()
+
+	// select the key store
+	var store keystore.Store
+	switch {
+	case *file != "":
+		store = keystore.NewFile(*file)
+	case *table != "":
+		sess := session.Must(session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		}))
+		store = keystore.NewDynamoDB(dynamodb.New(sess), *table)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(store, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(store keystore.Store, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := store.List()
+		if err != nil {
+			return fmt.Errorf("could not list keys: %s", err)
+		}
+		for _, k := range keys {
+			fmt.Printf("%s\t%s\t%s\t%s\n", k.Id, k.Algorithm, k.Status, k.Created.Format(time.RFC3339))
+		}
+		return nil
+
+	case "generate":
+		algorithm := token.RS256
+		if len(args) > 1 {
+			algorithm = args[1]
+		}
+		k, err := keystore.Generate(store, algorithm)
+		if err != nil {
+			return fmt.Errorf("could not generate key: %s", err)
+		}
+		fmt.Println(k.Id)
+		return nil
+
+	case "promote", "retire":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if args[0] == "promote" {
+			return keystore.Promote(store, args[1])
+		}
+		return keystore.Retire(store, args[1])
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	return nil
+}
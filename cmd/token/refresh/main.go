@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger    *zap.Logger
+	Issuer    token.Issuer
+	Validator token.Validator
+}
+
+type tokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func main() {
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// load keys from the key store, the keys are cached so the
+	// store is not hit on every request
+	keys := token.NewCachedKeySource(
+		keystore.KeySource{
+			Store: keystore.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_KEYS_TABLE_ID")),
+		},
+		keystore.CacheTTL,
+	)
+
+	// get token lifetime in seconds
+	ttl, err := strconv.Atoi(os.Getenv("CONFIG_TOKEN_TTL"))
+	if err != nil {
+		logger.Fatal("could not parse token TTL", zap.Error(err))
+	}
+
+	// get maximal lifetime of the token family in seconds
+	maxLifetime, err := strconv.Atoi(os.Getenv("CONFIG_TOKEN_MAX_LIFETIME"))
+	if err != nil {
+		logger.Fatal("could not parse token max lifetime", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Issuer: token.Issuer{
+					Keys:        keys,
+					Audience:    os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+					TTL:         time.Duration(ttl) * time.Second,
+					MaxLifetime: time.Duration(maxLifetime) * time.Second,
+				},
+				Validator: token.Validator{
+					Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+				},
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// only a valid token verified by the authorizer can be refreshed,
+		// the old token stays valid until it expires so the connections
+		// opened with it are kept, the refreshed token belongs to the same
+		// family and is granted the same scopes
+		userId, ok := apigw.HTTPAuthorizerString(req, apigw.AuthorizerUserIdKey)
+		if !ok || userId == "" {
+			d.Logger.Error("missing userId in authorizer context")
+			return apigw.UnauthorizedResponse(), nil
+		}
+
+		familyId, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerTokenIdKey)
+		authTime, _ := apigw.HTTPAuthorizerInt64(req, apigw.AuthorizerAuthTimeKey)
+		if familyId == "" || authTime == 0 {
+			d.Logger.Error("missing token in authorizer context",
+				zap.String("userId", userId),
+			)
+			return apigw.UnauthorizedResponse(), nil
+		}
+
+		scopes, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerScopesKey)
+
+		// the authorizer response might be cached, so the family
+		// or the user might have been revoked in the meantime
+		err := d.Validator.CheckRevoked(familyId)
+		if err == nil {
+			err = d.Validator.CheckUserRevoked(userId, authTime)
+		}
+		if errors.Is(err, token.ErrRevoked) {
+			d.Logger.Info("token revoked",
+				zap.String("userId", userId),
+				zap.String("fam", familyId),
+			)
+			return apigw.UnauthorizedResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not check token revocation",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		// issue the token, the family can't outlive its maximal lifetime
+		t, refreshed, err := d.Issuer.Refresh(token.Claims{
+			UserId:   userId,
+			Audience: d.Issuer.Audience,
+			FamilyId: familyId,
+			AuthTime: authTime,
+			Scope:    scopes,
+		})
+		if errors.Is(err, token.ErrLifetimeExceeded) {
+			d.Logger.Info("token can't be refreshed anymore",
+				zap.String("userId", userId),
+				zap.String("fam", familyId),
+			)
+			return apigw.UnauthorizedResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not issue token",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not issue token: %s", err)
+		}
+
+		d.Logger.Info("token refreshed",
+			zap.String("userId", userId),
+			zap.String("fam", familyId),
+			zap.String("refreshedJti", refreshed.Id),
+		)
+
+		// all good
+		return apigw.JSONResponse(http.StatusOK, tokenResponse{
+			Token:     t,
+			ExpiresAt: refreshed.ExpiresAt,
+		})
+	}
+}
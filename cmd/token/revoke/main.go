@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/keystore"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger      *zap.Logger
+	Validator   token.Validator
+	Revocations revocation.Store
+	Connections connection.ConnectionStore
+	SQS         sqsiface.SQSAPI
+	SQSURL      string
+	MaxLifetime time.Duration
+}
+
+// revokeRequest optionally names another token of the same user
+// to be revoked instead of the one used for authentication
+type revokeRequest struct {
+	Token string `json:"token"`
+}
+
+func main() {
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create SQS client
+	sqsSvc := sqs.New(sess)
+
+	// get dynamodb table and index name
+	table := os.Getenv("CONFIG_CONNECTIONS_TABLE_ID")
+	index := os.Getenv("CONFIG_TOKEN_ID_INDEX_NAME")
+
+	// get delete connection queue URL
+	queue := os.Getenv("CONFIG_SQS_DELETE_CONNECTION_URL")
+
+	revocations := revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID"))
+
+	// create connection store, the connections are looked up by the token
+	connections := connection.NewDynamoDB(dynamoDbSvc, table)
+	connections.TokenIdIndex = index
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// get maximal lifetime of the token family in seconds
+	maxLifetime, err := strconv.Atoi(os.Getenv("CONFIG_TOKEN_MAX_LIFETIME"))
+	if err != nil {
+		logger.Fatal("could not parse token max lifetime", zap.Error(err))
+	}
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger: logger,
+				Validator: token.Validator{
+					Keys:        token.NewJWKSSource(os.Getenv("CONFIG_JWKS_URL"), keystore.CacheTTL),
+					Audience:    os.Getenv("CONFIG_TOKEN_AUDIENCE"),
+					Revocations: revocations,
+				},
+				Revocations: revocations,
+				Connections: connections,
+				SQS:         sqsSvc,
+				SQSURL:      queue,
+				MaxLifetime: time.Duration(maxLifetime) * time.Second,
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// get the caller's token verified by the authorizer
+		userId, ok := apigw.HTTPAuthorizerString(req, apigw.AuthorizerUserIdKey)
+		if !ok || userId == "" {
+			d.Logger.Error("missing userId in authorizer context")
+			return apigw.UnauthorizedResponse(), nil
+		}
+
+		familyId, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerTokenIdKey)
+		authTime, _ := apigw.HTTPAuthorizerInt64(req, apigw.AuthorizerAuthTimeKey)
+		if familyId == "" || authTime == 0 {
+			d.Logger.Error("missing token in authorizer context",
+				zap.String("userId", userId),
+			)
+			return apigw.UnauthorizedResponse(), nil
+		}
+
+		claims := token.Claims{
+			UserId:   userId,
+			FamilyId: familyId,
+			AuthTime: authTime,
+		}
+
+		// get the token to be revoked
+		body, err := apigw.HTTPRequestBody(req)
+		if err != nil {
+			d.Logger.Error("could not decode request body",
+				zap.Error(err),
+			)
+			return apigw.BadRequestResponse(), nil
+		}
+
+		r := revokeRequest{}
+		if body != "" {
+			err = json.Unmarshal([]byte(body), &r)
+			if err != nil {
+				d.Logger.Error("could not parse request",
+					zap.Error(err),
+				)
+				return apigw.BadRequestResponse(), nil
+			}
+		}
+
+		if r.Token != "" {
+			other, err := d.Validator.Validate(r.Token)
+			if err != nil || other.UserId != claims.UserId {
+				d.Logger.Info("token can't be revoked",
+					zap.String("userId", claims.UserId),
+					zap.Error(err),
+				)
+				return apigw.BadRequestResponse(), nil
+			}
+			claims = other
+		}
+
+		// store the revocation of the whole family, so the tokens refreshed
+		// from the token are revoked too, it's needed only until the last
+		// token of the family can expire
+		err = d.Revocations.Revoke(claims.Family(), claims.FamilyExpiresAt(d.MaxLifetime))
+		if err != nil {
+			d.Logger.Error("could not revoke token",
+				zap.String("fam", claims.Family()),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not revoke token: %s", err)
+		}
+
+		d.Logger.Info("token revoked",
+			zap.String("userId", claims.UserId),
+			zap.String("fam", claims.Family()),
+		)
+
+		// close all connections opened with the tokens of the family
+		conns, err := d.Connections.GetByTokenId(claims.Family())
+		if err != nil {
+			d.Logger.Error("could get list of connections",
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not get connections: %s", err)
+		}
+
+		for _, c := range conns {
+			d.Logger.Info("closing connection",
+				zap.String("fam", claims.Family()),
+				zap.String("connectionId", c.ConnectionId),
+			)
+
+			r := request.DeleteConnectionFromId(c.ConnectionId)
+			r.Reason = request.ReasonRevoked
+
+			err = r.DeleteSQS(d.SQS, d.SQSURL)
+			if err != nil {
+				d.Logger.Error("could not request deletion of connection",
+					zap.String("connectionId", c.ConnectionId),
+					zap.Error(err),
+				)
+				return apigw.InternalServerErrorResponse(), fmt.Errorf("could not request deletion of connection: %s", err)
+			}
+		}
+
+		// all good
+		return apigw.OkResponse(), nil
+	}
+}
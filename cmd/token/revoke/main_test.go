@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/tokentest"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+const deleteQueue = "delete"
+
+type testDependencies struct {
+	handlerDependencies
+	revocations *revocation.Memory
+	sqs         *awstest.SQS
+	issuer      token.Issuer
+}
+
+func newTestDependencies(t *testing.T) testDependencies {
+	t.Helper()
+
+	keys := tokentest.Keys(t)
+	td := testDependencies{
+		revocations: revocation.NewMemory(),
+		sqs:         awstest.NewSQS(),
+		issuer:      tokentest.Issuer(keys),
+	}
+
+	connections := connection.NewMemory()
+	td.handlerDependencies = handlerDependencies{
+		Logger:      zap.NewNop(),
+		Validator:   tokentest.Validator(keys, td.revocations),
+		Revocations: td.revocations,
+		Connections: connections,
+		SQS:         td.sqs,
+		SQSURL:      deleteQueue,
+		MaxLifetime: time.Hour,
+	}
+
+	// the connections opened with the tokens of two families
+	for id, tokenId := range map[string]string{"c1": "fam1", "c2": "fam1", "c3": "fam2"} {
+		c := connection.New(id, "1234")
+		c.Authorized = true
+		c.TokenId = tokenId
+		if err := connections.Create(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return td
+}
+
+// revoke calls the route authorized with the token of the family
+func (td testDependencies) revoke(t *testing.T, familyId string, body string) int {
+	t.Helper()
+
+	res, err := handler(td.handlerDependencies)(context.Background(), events.APIGatewayV2HTTPRequest{
+		Body: body,
+		// the context is decoded from json, so the numbers are float64
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				Lambda: map[string]interface{}{
+					apigw.AuthorizerUserIdKey:   "1234",
+					apigw.AuthorizerTokenIdKey:  familyId,
+					apigw.AuthorizerAuthTimeKey: float64(time.Now().Unix()),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode
+}
+
+// closed returns the connections requested to be closed
+func (td testDependencies) closed(t *testing.T) map[string]bool {
+	t.Helper()
+
+	closed := map[string]bool{}
+	for _, m := range td.sqs.Messages(deleteQueue) {
+		r, err := request.DeleteConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil || r.Reason != request.ReasonRevoked {
+			t.Fatalf("unexpected deletion %s", aws.StringValue(m.MessageBody))
+		}
+		closed[r.ConnectionId] = true
+	}
+
+	return closed
+}
+
+func TestRevoke(t *testing.T) {
+	td := newTestDependencies(t)
+
+	if status := td.revoke(t, "fam1", ""); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	// the whole family is revoked and its connections closed
+	if err := td.Validator.CheckRevoked("fam1"); !errors.Is(err, token.ErrRevoked) {
+		t.Fatalf("expected revoked family, got %v", err)
+	}
+	if closed := td.closed(t); len(closed) != 2 || !closed["c1"] || !closed["c2"] {
+		t.Fatalf("expected c1 and c2 closed, got %v", closed)
+	}
+}
+
+func TestRevokeOtherToken(t *testing.T) {
+	td := newTestDependencies(t)
+
+	tok, claims, err := td.issuer.Issue("1234", []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(revokeRequest{Token: tok})
+	if status := td.revoke(t, "fam1", string(body)); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	// the named token is revoked instead of the caller's one
+	if err := td.Validator.CheckRevoked(claims.Family()); !errors.Is(err, token.ErrRevoked) {
+		t.Fatalf("expected revoked family, got %v", err)
+	}
+	if err := td.Validator.CheckRevoked("fam1"); err != nil {
+		t.Fatalf("expected the caller's family to be valid, got %v", err)
+	}
+}
+
+func TestRevokeTokenOfAnotherUser(t *testing.T) {
+	td := newTestDependencies(t)
+
+	tok, _, err := td.issuer.Issue("5678", []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(revokeRequest{Token: tok})
+	if status := td.revoke(t, "fam1", string(body)); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, status)
+	}
+	if closed := td.closed(t); len(closed) > 0 {
+		t.Fatalf("expected no connection closed, got %v", closed)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/ticket"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type handlerDependencies struct {
+	Logger    *zap.Logger
+	Tickets   ticket.Store
+	Validator token.Validator
+}
+
+type ticketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func main() {
+	// create AWS session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// create AWS dynamodb client
+	dynamoDbSvc := dynamodb.New(sess)
+
+	// create a logger
+	logger, _ := zap.NewProduction()
+
+	// start the main handler
+	lambda.Start(
+		handler(
+			handlerDependencies{
+				Logger:  logger,
+				Tickets: ticket.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_TICKETS_TABLE_ID")),
+				Validator: token.Validator{
+					Revocations: revocation.NewDynamoDB(dynamoDbSvc, os.Getenv("CONFIG_REVOCATIONS_TABLE_ID")),
+				},
+			},
+		),
+	)
+}
+
+func handler(d handlerDependencies) func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+	return func(_ context.Context, req events.APIGatewayV2HTTPRequest) (apigw.Response, error) {
+
+		// the ticket is minted only for the holder of a valid token
+		// verified by the authorizer
+		userId, ok := apigw.HTTPAuthorizerString(req, apigw.AuthorizerUserIdKey)
+		if !ok || userId == "" {
+			d.Logger.Error("missing userId in authorizer context")
+			return apigw.UnauthorizedResponse(), nil
+		}
+
+		tokenId, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerTokenIdKey)
+		scopes, _ := apigw.HTTPAuthorizerString(req, apigw.AuthorizerScopesKey)
+		expiresAt, _ := apigw.HTTPAuthorizerInt64(req, apigw.AuthorizerExpiresKey)
+		authTime, _ := apigw.HTTPAuthorizerInt64(req, apigw.AuthorizerAuthTimeKey)
+
+		// the authorizer response might be cached, so the token
+		// or the user might have been revoked in the meantime
+		err := d.Validator.CheckRevoked(tokenId)
+		if err == nil {
+			err = d.Validator.CheckUserRevoked(userId, authTime)
+		}
+		if errors.Is(err, token.ErrRevoked) {
+			d.Logger.Info("token revoked",
+				zap.String("userId", userId),
+				zap.String("tokenId", tokenId),
+			)
+			return apigw.UnauthorizedResponse(), nil
+		}
+		if err != nil {
+			d.Logger.Error("could not check token revocation",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		// create and store the ticket
+		t, err := ticket.New(userId, tokenId, token.SplitScopes(scopes), expiresAt)
+		if err != nil {
+			d.Logger.Error("could not create ticket",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+		t.AuthTime = authTime
+
+		err = d.Tickets.Put(t)
+		if err != nil {
+			d.Logger.Error("could not store ticket",
+				zap.String("userId", userId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), fmt.Errorf("could not store ticket: %s", err)
+		}
+
+		d.Logger.Info("ticket issued",
+			zap.String("userId", userId),
+		)
+
+		// all good
+		return apigw.JSONResponse(http.StatusOK, ticketResponse{
+			Ticket:    t.TicketId,
+			ExpiresAt: t.ExpiresAt,
+		})
+	}
+}
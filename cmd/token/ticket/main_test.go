@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+	"github.com/pipetail/sst-websocket/pkg/ticket"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+func ticketRequest(authTime int64) events.APIGatewayV2HTTPRequest {
+	return events.APIGatewayV2HTTPRequest{
+		// the context is decoded from json, so the numbers are float64
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				Lambda: map[string]interface{}{
+					apigw.AuthorizerUserIdKey:   "1234",
+					apigw.AuthorizerTokenIdKey:  "fam",
+					apigw.AuthorizerScopesKey:   "ping",
+					apigw.AuthorizerExpiresKey:  float64(time.Now().Add(time.Hour).Unix()),
+					apigw.AuthorizerAuthTimeKey: float64(authTime),
+				},
+			},
+		},
+	}
+}
+
+func TestTicket(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name    string
+		revoke  func(r *revocation.Memory) error
+		status  int
+		created bool
+	}{
+		{
+			name:    "valid token",
+			revoke:  func(*revocation.Memory) error { return nil },
+			status:  http.StatusOK,
+			created: true,
+		},
+		{
+			name: "token revoked after the authorizer cached it",
+			revoke: func(r *revocation.Memory) error {
+				return r.Revoke("fam", now+3600)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "user logged out after the authorizer cached the token",
+			revoke: func(r *revocation.Memory) error {
+				return r.RevokeUser("1234", now+1, now+3600)
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tickets := ticket.NewMemory()
+			revocations := revocation.NewMemory()
+			if err := tt.revoke(revocations); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := handler(handlerDependencies{
+				Logger:  zap.NewNop(),
+				Tickets: tickets,
+				Validator: token.Validator{
+					Revocations: revocations,
+				},
+			})(context.Background(), ticketRequest(now))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, res.StatusCode)
+			}
+			if !tt.created {
+				return
+			}
+
+			body := ticketResponse{}
+			if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			// the ticket carries the token of the authorizer
+			tk, err := tickets.Consume(body.Ticket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tk.UserId != "1234" || tk.TokenId != "fam" || tk.AuthTime != now {
+				t.Fatalf("unexpected ticket %+v", tk)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Package awstest provides in-memory fakes of the AWS clients used by the
+// handlers, they only record the calls so the handlers can be tested
+// on top of the in-memory stores
+package awstest
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// SQS records the sent messages, the sends to the queues listed in
+// Errors fail with the given error
+type SQS struct {
+	sqsiface.SQSAPI
+
+	mu       sync.Mutex
+	Errors   map[string]error
+	messages []*sqs.SendMessageInput
+}
+
+// NewSQS creates the fake with no failing queues
+func NewSQS() *SQS {
+	return &SQS{
+		Errors: map[string]error{},
+	}
+}
+
+// SendMessage implements sqsiface.SQSAPI
+func (s *SQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.Errors[aws.StringValue(input.QueueUrl)]; err != nil {
+		return nil, err
+	}
+
+	s.messages = append(s.messages, input)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// Messages returns the messages sent to the queue in the order they were sent
+func (s *SQS) Messages(url string) []*sqs.SendMessageInput {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []*sqs.SendMessageInput{}
+	for _, m := range s.messages {
+		if aws.StringValue(m.QueueUrl) == url {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
+
+// ApiGateway records the posted data and the deleted connections, the calls
+// for the connections listed in Errors fail with the given error, only the
+// connections listed in Live are reported by GetConnection
+type ApiGateway struct {
+	apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+
+	mu      sync.Mutex
+	Errors  map[string]error
+	Live    map[string]*apigatewaymanagementapi.GetConnectionOutput
+	posted  map[string][]string
+	deleted []string
+}
+
+// NewApiGateway creates the fake with no failing connections
+func NewApiGateway() *ApiGateway {
+	return &ApiGateway{
+		Errors: map[string]error{},
+		Live:   map[string]*apigatewaymanagementapi.GetConnectionOutput{},
+		posted: map[string][]string{},
+	}
+}
+
+// GetConnection implements apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+func (a *ApiGateway) GetConnection(input *apigatewaymanagementapi.GetConnectionInput) (*apigatewaymanagementapi.GetConnectionOutput, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	connectionId := aws.StringValue(input.ConnectionId)
+	if err := a.Errors[connectionId]; err != nil {
+		return nil, err
+	}
+
+	live, ok := a.Live[connectionId]
+	if !ok {
+		return nil, awserr.New(apigatewaymanagementapi.ErrCodeGoneException, "connection is gone", nil)
+	}
+
+	return live, nil
+}
+
+// PostToConnection implements apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+func (a *ApiGateway) PostToConnection(input *apigatewaymanagementapi.PostToConnectionInput) (*apigatewaymanagementapi.PostToConnectionOutput, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	connectionId := aws.StringValue(input.ConnectionId)
+	if err := a.Errors[connectionId]; err != nil {
+		return nil, err
+	}
+
+	a.posted[connectionId] = append(a.posted[connectionId], string(input.Data))
+	return &apigatewaymanagementapi.PostToConnectionOutput{}, nil
+}
+
+// DeleteConnection implements apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+func (a *ApiGateway) DeleteConnection(input *apigatewaymanagementapi.DeleteConnectionInput) (*apigatewaymanagementapi.DeleteConnectionOutput, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	connectionId := aws.StringValue(input.ConnectionId)
+	if err := a.Errors[connectionId]; err != nil {
+		return nil, err
+	}
+
+	a.deleted = append(a.deleted, connectionId)
+	return &apigatewaymanagementapi.DeleteConnectionOutput{}, nil
+}
+
+// Posted returns the data posted to the connection
+func (a *ApiGateway) Posted(connectionId string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]string{}, a.posted[connectionId]...)
+}
+
+// Deleted returns the deleted connections
+func (a *ApiGateway) Deleted() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]string{}, a.deleted...)
+}
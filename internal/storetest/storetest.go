@@ -0,0 +1,41 @@
+// Package storetest opens the connections the handlers are tested on, the
+// records are created in the in-memory stores the same way $connect
+// creates them
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pipetail/sst-websocket/pkg/connection"
+)
+
+// Counter counts the connections of the user, it's implemented by
+// presence.Store
+type Counter interface {
+	Acquire(userId string, max int) (bool, error)
+}
+
+// Open creates the records of the connections of the user with the session
+// lasting an hour and counts them if the counter is given, the connections
+// of the empty user are anonymous, the connections opened in the same second
+// are ordered by their ids so the last id is the newest
+func Open(t *testing.T, connections connection.ConnectionStore, counter Counter, userId string, connectionIds ...string) {
+	t.Helper()
+
+	for _, connectionId := range connectionIds {
+		c := connection.New(connectionId, userId)
+		c.Authorized = userId != ""
+		c.SessionExpiresAt = time.Now().Add(time.Hour).Unix()
+		if err := connections.Create(c); err != nil {
+			t.Fatal(err)
+		}
+
+		if counter == nil || userId == "" {
+			continue
+		}
+		if _, err := counter.Acquire(userId, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Package tokentest issues the tokens the handlers are tested with, the
+// tokens are signed by a static HS256 key instead of the keys table
+package tokentest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pipetail/sst-websocket/pkg/token"
+)
+
+// Audience of the issued tokens
+const Audience = "wsapi"
+
+// Keys returns the key source with the single HS256 signing key
+func Keys(t *testing.T) token.StaticKeySource {
+	t.Helper()
+
+	key, err := token.NewHS256Key([]byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Id = "hs"
+
+	return token.StaticKeySource{Set: token.KeySet{Keys: []token.Key{key}, SigningKeyId: key.Id}}
+}
+
+// Issuer returns the issuer of the tokens valid for a minute signed by the keys
+func Issuer(keys token.KeySource) token.Issuer {
+	return token.Issuer{
+		Keys:        keys,
+		Audience:    Audience,
+		TTL:         time.Minute,
+		MaxLifetime: time.Hour,
+	}
+}
+
+// Validator returns the validator of the tokens signed by the keys
+func Validator(keys token.KeySource, revocations token.RevocationChecker) token.Validator {
+	return token.Validator{
+		Keys:        keys,
+		Audience:    Audience,
+		Revocations: revocations,
+	}
+}
@@ -1,9 +1,26 @@
 package apigw
 
+import "strings"
+
+// keys of the values passed by the authorizer to the integrations, the
+// tokenId is the family id of the token so everything authorized by it
+// is revoked along with the tokens refreshed from it
+const (
+	AuthorizerUserIdKey   = "userId"
+	AuthorizerTokenIdKey  = "tokenId"
+	AuthorizerScopesKey   = "scopes"
+	AuthorizerExpiresKey  = "expiresAt"
+	AuthorizerAuthTimeKey = "authTime"
+)
+
+// APIGatewayV2CustomAuthorizerRequest is the request of the REQUEST
+// authorizer, the HTTP APIs send the routeArn while the WebSocket APIs
+// send the methodArn of the $connect route
 type APIGatewayV2CustomAuthorizerRequest struct {
 	Version               string            `json:"version"`
 	Type                  string            `json:"type"`
 	RouteARN              string            `json:"routeArn"`
+	MethodARN             string            `json:"methodArn"`
 	IdentitySource        []string          `json:"identitySource"`
 	RouteKey              string            `json:"routeKey"`
 	RawPath               string            `json:"rawPath"`
@@ -15,9 +32,11 @@ type APIGatewayV2CustomAuthorizerRequest struct {
 	StageVariables        map[string]string `json:"stageVariables"`
 }
 
+// APIGatewayV2CustomAuthorizerResponse is the IAM policy response format,
+// the context values can be strings, numbers or booleans
 type APIGatewayV2CustomAuthorizerResponse struct {
 	PrincipalId    string                                             `json:"principalId"`
-	Context        map[string]string                                  `json:"context"`
+	Context        map[string]interface{}                             `json:"context"`
 	PolicyDocument APIGatewayV2CustomAuthorizerResponsePolicyDocument `json:"policyDocument"`
 }
 
@@ -61,6 +80,41 @@ func AuthorizerAllow(arn string, user string) APIGatewayV2CustomAuthorizerRespon
 				},
 			},
 		},
-		Context: map[string]string{},
+		Context: map[string]interface{}{},
+	}
+}
+
+// APIGatewayV2SimpleAuthorizerResponse is the simple response format
+// supported by HTTP APIs with payload format version 2.0
+type APIGatewayV2SimpleAuthorizerResponse struct {
+	IsAuthorized bool                   `json:"isAuthorized"`
+	Context      map[string]interface{} `json:"context,omitempty"`
+}
+
+func AuthorizerSimpleDeny() APIGatewayV2SimpleAuthorizerResponse {
+	return APIGatewayV2SimpleAuthorizerResponse{
+		IsAuthorized: false,
 	}
 }
+
+func AuthorizerSimpleAllow() APIGatewayV2SimpleAuthorizerResponse {
+	return APIGatewayV2SimpleAuthorizerResponse{
+		IsAuthorized: true,
+		Context:      map[string]interface{}{},
+	}
+}
+
+// WildcardRouteARN turns the ARN of the route into the ARN matching all
+// routes of the same API stage, the policy with such resource can be
+// cached and reused for the other routes
+//
+//	arn:aws:execute-api:eu-west-1:123456789012:abcdef/prod/POST/token/refresh
+//	arn:aws:execute-api:eu-west-1:123456789012:abcdef/prod/*
+func WildcardRouteARN(arn string) string {
+	parts := strings.SplitN(arn, "/", 3)
+	if len(parts) < 2 {
+		return arn
+	}
+
+	return parts[0] + "/" + parts[1] + "/*"
+}
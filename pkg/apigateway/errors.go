@@ -0,0 +1,50 @@
+package apigw
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+)
+
+// ErrorClass tells how to handle the error of the management API
+type ErrorClass int
+
+// the zero value is ErrorPermanent so an unset class never makes the
+// callers forget the connection
+const (
+	// ErrorPermanent means the call fails no matter how often it's retried
+	ErrorPermanent ErrorClass = iota
+
+	// ErrorGone means the connection is closed and never comes back
+	ErrorGone
+
+	// ErrorRetryable means the call may succeed later, e.g. throttling
+	ErrorRetryable
+)
+
+// ClassifyError classifies the error returned by the management API
+func ClassifyError(err error) ErrorClass {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case apigatewaymanagementapi.ErrCodeGoneException:
+			return ErrorGone
+		case apigatewaymanagementapi.ErrCodeLimitExceededException:
+			return ErrorRetryable
+		}
+	}
+
+	if request.IsErrorThrottle(err) || request.IsErrorRetryable(err) {
+		return ErrorRetryable
+	}
+
+	// server side failures are transient
+	var rerr awserr.RequestFailure
+	if errors.As(err, &rerr) && rerr.StatusCode() >= 500 {
+		return ErrorRetryable
+	}
+
+	return ErrorPermanent
+}
@@ -0,0 +1,158 @@
+package apigw
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// HTTPRequestBody returns body of the HTTP API request, decoded if API Gateway
+// passed it base64 encoded
+func HTTPRequestBody(req events.APIGatewayV2HTTPRequest) (string, error) {
+	if !req.IsBase64Encoded {
+		return req.Body, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Body)
+	return string(data), err
+}
+
+// Header returns the header value, header names are case-insensitive
+func Header(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// BearerToken extracts token from the Authorization header, header names
+// are matched case-insensitively
+func BearerToken(headers map[string]string) string {
+	for name, value := range headers {
+		if !strings.EqualFold(name, "authorization") {
+			continue
+		}
+
+		if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+			return strings.TrimSpace(value[7:])
+		}
+	}
+
+	return ""
+}
+
+// ProtocolTokenPrefix marks the entry of the Sec-WebSocket-Protocol header
+// carrying the token, browsers can't set any other header on the upgrade
+const ProtocolTokenPrefix = "bearer."
+
+// ProtocolToken extracts token from the Sec-WebSocket-Protocol header
+// entry starting with ProtocolTokenPrefix
+func ProtocolToken(headers map[string]string) string {
+	for _, p := range strings.Split(Header(headers, "sec-websocket-protocol"), ",") {
+		p = strings.TrimSpace(p)
+		if len(p) > len(ProtocolTokenPrefix) && strings.HasPrefix(p, ProtocolTokenPrefix) {
+			return p[len(ProtocolTokenPrefix):]
+		}
+	}
+
+	return ""
+}
+
+// Subprotocols returns the subprotocols offered in the Sec-WebSocket-Protocol
+// header in the order of preference, the entry carrying the token is skipped
+// so it's never echoed back as the negotiated subprotocol
+func Subprotocols(headers map[string]string) []string {
+	protocols := []string{}
+	for _, p := range strings.Split(Header(headers, "sec-websocket-protocol"), ",") {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, ProtocolTokenPrefix) {
+			continue
+		}
+		protocols = append(protocols, p)
+	}
+
+	return protocols
+}
+
+// AuthorizerValue returns the string value set by the Lambda authorizer
+// in the request context of the websocket request
+func AuthorizerValue(req *events.APIGatewayWebsocketProxyRequest, key string) (string, bool) {
+	values, ok := req.RequestContext.Authorizer.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+
+	value, ok := values[key].(string)
+	return value, ok
+}
+
+// AuthorizerInt64 returns the numeric value set by the Lambda authorizer in
+// the request context of the websocket request, API Gateway may pass the
+// numbers converted to strings
+func AuthorizerInt64(req *events.APIGatewayWebsocketProxyRequest, key string) (int64, bool) {
+	values, ok := req.RequestContext.Authorizer.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+
+	switch v := values[key].(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// AuthorizerValues returns all string values set by the Lambda authorizer
+// in the request context of the websocket request
+func AuthorizerValues(req *events.APIGatewayWebsocketProxyRequest) map[string]string {
+	values, ok := req.RequestContext.Authorizer.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	res := map[string]string{}
+	for k, v := range values {
+		// principalId is added by API Gateway to the context
+		if s, ok := v.(string); ok && k != "principalId" {
+			res[k] = s
+		}
+	}
+
+	return res
+}
+
+// WebsocketHandler is the signature of the websocket route handlers
+type WebsocketHandler func(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (Response, error)
+
+// HTTPAuthorizerString returns the string value set by the Lambda authorizer
+// in the request context of the HTTP API request
+func HTTPAuthorizerString(req events.APIGatewayV2HTTPRequest, key string) (string, bool) {
+	if req.RequestContext.Authorizer == nil {
+		return "", false
+	}
+
+	value, ok := req.RequestContext.Authorizer.Lambda[key].(string)
+	return value, ok
+}
+
+// HTTPAuthorizerInt64 returns the numeric value set by the Lambda authorizer
+// in the request context of the HTTP API request
+func HTTPAuthorizerInt64(req events.APIGatewayV2HTTPRequest, key string) (int64, bool) {
+	if req.RequestContext.Authorizer == nil {
+		return 0, false
+	}
+
+	// json numbers are decoded as float64
+	value, ok := req.RequestContext.Authorizer.Lambda[key].(float64)
+	return int64(value), ok
+}
@@ -1,6 +1,8 @@
 package apigw
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -24,3 +26,34 @@ func BadRequestResponse() Response {
 func OkResponse() Response {
 	return Response{StatusCode: http.StatusOK}
 }
+
+// AcceptedResponse returns an Amazon API Gateway Proxy Response configured with the correct HTTP status code.
+func AcceptedResponse() Response {
+	return Response{StatusCode: http.StatusAccepted}
+}
+
+// UnauthorizedResponse returns an Amazon API Gateway Proxy Response configured with the correct HTTP status code.
+func UnauthorizedResponse() Response {
+	return Response{StatusCode: http.StatusUnauthorized}
+}
+
+// ForbiddenResponse returns an Amazon API Gateway Proxy Response configured with the correct HTTP status code.
+func ForbiddenResponse() Response {
+	return Response{StatusCode: http.StatusForbidden}
+}
+
+// JSONResponse returns an Amazon API Gateway Proxy Response with the given HTTP status code and json encoded body.
+func JSONResponse(statusCode int, body interface{}) (Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return InternalServerErrorResponse(), fmt.Errorf("could not encode response body: %s", err)
+	}
+
+	return Response{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(data),
+	}, nil
+}
@@ -0,0 +1,9 @@
+package checkpoint
+
+// Store keeps the positions where the long running jobs stopped, so
+// the next run continues instead of starting over, empty value means
+// the job starts from the beginning
+type Store interface {
+	Load(name string) (string, error)
+	Save(name string, value string) error
+}
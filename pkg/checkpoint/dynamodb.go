@@ -0,0 +1,69 @@
+package checkpoint
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDB stores the checkpoints in the given DynamoDB table keyed by Name
+type DynamoDB struct {
+	DynamoDB  *dynamodb.DynamoDB
+	TableName string
+}
+
+// NewDynamoDB creates checkpoint store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// Load implements Store
+func (d DynamoDB) Load(name string) (string, error) {
+	res, err := d.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"Name": {
+				S: aws.String(name),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(d.TableName),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if res.Item == nil || res.Item["Value"] == nil {
+		return "", nil
+	}
+
+	return aws.StringValue(res.Item["Value"].S), nil
+}
+
+// Save implements Store, the empty value removes the checkpoint
+func (d DynamoDB) Save(name string, value string) error {
+	key := map[string]*dynamodb.AttributeValue{
+		"Name": {
+			S: aws.String(name),
+		},
+	}
+
+	if value == "" {
+		_, err := d.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+			Key:       key,
+			TableName: aws.String(d.TableName),
+		})
+		return err
+	}
+
+	key["Value"] = &dynamodb.AttributeValue{
+		S: aws.String(value),
+	}
+
+	_, err := d.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		Item:      key,
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
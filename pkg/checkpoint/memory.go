@@ -0,0 +1,40 @@
+package checkpoint
+
+import (
+	"sync"
+)
+
+// Memory stores the checkpoints in memory, it's safe for concurrent use
+type Memory struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// NewMemory creates an empty in-memory checkpoint store
+func NewMemory() *Memory {
+	return &Memory{
+		values: map[string]string{},
+	}
+}
+
+// Load implements Store
+func (m *Memory) Load(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.values[name], nil
+}
+
+// Save implements Store
+func (m *Memory) Save(name string, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if value == "" {
+		delete(m.values, name)
+		return nil
+	}
+
+	m.values[name] = value
+	return nil
+}
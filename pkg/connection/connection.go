@@ -1,17 +1,70 @@
 package connection
 
 import (
+	"errors"
+	"sort"
 	"time"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
+var ErrNotFound = errors.New("connection not found")
+
+// MaxSession is how long the record of the connection without known
+// session expiration is kept
+const MaxSession = 24 * time.Hour
+
+// ExpiryGrace is added to the session expiration so the record outlives
+// the connection until it's closed by cmd/delete_connection
+const ExpiryGrace = 5 * time.Minute
+
+// Connection is a record of the opened websocket connection, the connection
+// is Authorized once its user was verified either by the authorizer
+// during $connect or later by the authorize action
 type Connection struct {
 	ConnectionId string
-	UserId       string
-	Created      time.Time
+	UserId       string `dynamodbav:",omitempty"`
+	TokenId      string `dynamodbav:",omitempty"`
+	Authorized   bool
+	Principal    string            `dynamodbav:",omitempty"`
+	Claims       map[string]string `dynamodbav:",omitempty"`
+	Scopes       []string          `dynamodbav:",omitempty"`
+
+	// Created is stored as epoch time so it can be used as the sort
+	// key of the user index and the most recent connections can be
+	// queried, the records written before are RFC3339 strings which
+	// are still decoded but left out of the index until migrated by
+	// cmd/connections/migrate
+	Created time.Time `dynamodbav:",unixtime"`
+
+	// SessionExpiresAt is the epoch time when the connection is closed,
+	// it's derived from the expiration of the credentials
+	SessionExpiresAt int64
+
+	// ExpiresAt is the epoch time when the record is removed by DynamoDB
+	// TTL, it covers connections which were never cleaned up by $disconnect
+	ExpiresAt int64
+
+	// LastSeen is the epoch time of the last message received from
+	// the connection, idle connections are closed by cmd/reaper
+	LastSeen int64 `dynamodbav:",omitempty"`
+
+	// Evicted marks the connection closed to make room for a newer
+	// connection of the user, it's no longer counted towards the limit
+	Evicted bool `dynamodbav:",omitempty"`
+
+	// Closing is the epoch time when the reaper requested closing of the
+	// idle connection, it's not requested again on the next runs
+	Closing int64 `dynamodbav:",omitempty"`
+
+	// metadata captured at $connect so support can tell
+	// which device and client the user is on
+	SourceIp    string            `dynamodbav:",omitempty"`
+	UserAgent   string            `dynamodbav:",omitempty"`
+	DeviceId    string            `dynamodbav:",omitempty"`
+	AppVersion  string            `dynamodbav:",omitempty"`
+	DomainName  string            `dynamodbav:",omitempty"`
+	Stage       string            `dynamodbav:",omitempty"`
+	Subprotocol string            `dynamodbav:",omitempty"`
+	Labels      map[string]string `dynamodbav:",omitempty"`
 }
 
 func New(connectionId string, userId string) Connection {
@@ -33,73 +86,159 @@ func NewWithUserId(userId string) Connection {
 	}
 }
 
-// Create add supplied Connection struct to the given DynamoDB table
-func (connection Connection) Create(dynamoDbSvc *dynamodb.DynamoDB, table string) error {
-	// update time
-	connection.Created = time.Now()
-
-	// marshal
-	av, err := dynamodbattribute.MarshalMap(connection)
-	if err != nil {
-		return err
+func NewWithTokenId(tokenId string) Connection {
+	return Connection{
+		TokenId: tokenId,
 	}
+}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(table),
-	}
+// Expired tells whether the record outlived its expiration, DynamoDB
+// deletes expired items lazily so they have to be filtered out on read
+func (connection Connection) Expired() bool {
+	return connection.ExpiresAt > 0 && connection.ExpiresAt <= time.Now().Unix()
+}
+
+// Counted tells whether the connection counts towards the limit of
+// connections of its user, the evicted connections were uncounted when
+// they were evicted
+func (connection Connection) Counted() bool {
+	return connection.UserId != "" && !connection.Evicted
+}
 
-	_, err = dynamoDbSvc.PutItem(input)
-	return err
+// Cursor is the position of the connection among the connections of its
+// user, the walk through the connections of the user continues after it
+type Cursor struct {
+	Created      int64  `json:"created"`
+	ConnectionId string `json:"connectionId"`
 }
 
-// Delete deletes connection by the provided ConnectioId
-func (connection Connection) Delete(dynamoDbSvc *dynamodb.DynamoDB, table string) error {
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"ConnectionId": {
-				S: aws.String(connection.ConnectionId),
-			},
-		},
-		TableName: aws.String(table),
+// Cursor returns the position of the connection
+func (connection Connection) Cursor() Cursor {
+	return Cursor{
+		Created:      connection.Created.Unix(),
+		ConnectionId: connection.ConnectionId,
 	}
+}
 
-	_, err := dynamoDbSvc.DeleteItem(input)
-	return err
+// IsZero tells whether the cursor points before the newest connection
+func (cursor Cursor) IsZero() bool {
+	return cursor.ConnectionId == ""
 }
 
-// GetByUserId return all connections opened by the given user
-func (connection Connection) GetByUserId(dynamoDbSvc *dynamodb.DynamoDB, table string, index string) ([]Connection, error) {
-	input := &dynamodb.QueryInput{
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":v1": {
-				S: aws.String(connection.UserId),
-			},
-		},
-		KeyConditionExpression: aws.String("UserId = :v1"),
-		TableName:              aws.String(table),
-		IndexName:              aws.String(index),
+// precedes tells whether the connection comes after the cursor, the
+// connections of the user are ordered from the newest, the connections
+// created in the same second by their ids
+func (cursor Cursor) precedes(c Connection) bool {
+	if cursor.IsZero() {
+		return true
 	}
 
-	// run the query
-	res, err := dynamoDbSvc.Query(input)
-	if err != nil {
-		return nil, err
+	created := c.Created.Unix()
+	if created != cursor.Created {
+		return created < cursor.Created
 	}
 
-	// prepare an emty slice
-	connections := []Connection{}
+	return c.ConnectionId < cursor.ConnectionId
+}
 
-	// go through items
-	for _, item := range res.Items {
-		c := Connection{}
-		err = dynamodbattribute.UnmarshalMap(item, &c)
-		if err != nil {
-			return nil, err
-		}
+// sortNewestFirst orders the connections of the user the way the cursor
+// expects them
+func sortNewestFirst(connections []Connection) {
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Cursor().precedes(connections[j])
+	})
+}
 
-		connections = append(connections, c)
+// RecordExpiresAt returns the expiration of the record for the given
+// session expiration
+func RecordExpiresAt(sessionExpiresAt int64) int64 {
+	if sessionExpiresAt <= 0 {
+		return time.Now().Add(MaxSession).Unix()
 	}
 
-	return connections, nil
+	return time.Unix(sessionExpiresAt, 0).Add(ExpiryGrace).Unix()
+}
+
+// ConnectionStore persists records of the opened connections
+type ConnectionStore interface {
+	// Create adds the connection, the creation time and the expiration
+	// of the record are set by the store
+	Create(c Connection) error
+
+	// Get returns the connection, expired records are not found
+	Get(connectionId string) (Connection, error)
+
+	// Find returns the connection even if its record expired but was
+	// not yet deleted by DynamoDB TTL, it's meant for closing and
+	// cleaning up the connections which outlived their records
+	Find(connectionId string) (Connection, error)
+
+	// Authorize marks the existing connection as authorized by the
+	// principal and refreshes the expiration of the record, it's used
+	// by the re-authentication as well to swap the token and extend
+	// the session, returns ErrNotFound if the connection is gone
+	Authorize(c Connection) error
+
+	// Evict marks the connection as evicted, returns ErrNotFound if the
+	// connection is gone or was already evicted, so concurrent connects
+	// never evict the same connection twice
+	Evict(connectionId string) error
+	Delete(connectionId string) error
+
+	// Remove deletes the connection and returns the deleted record, only
+	// one of concurrent callers gets the record, the others get ErrNotFound,
+	// so the connection is uncounted exactly once
+	Remove(connectionId string) (Connection, error)
+
+	// the lookups skip expired records
+
+	// GetByUserId returns all connections of the user, the lookups
+	// by the user skip the evicted connections as they are being
+	// closed and no longer count towards the limit
+	GetByUserId(userId string) ([]Connection, error)
+
+	// GetRecentByUserId returns at most limit most recent connections
+	// of the user, the newest first
+	GetRecentByUserId(userId string, limit int) ([]Connection, error)
+
+	// EachByUserId calls fn for the connections of the user as they are
+	// loaded, the newest first, limit 0 means all connections, iteration
+	// stops at the first error returned by fn
+	EachByUserId(userId string, limit int, fn func(c Connection) error) error
+
+	// EachByUserIdAfter calls fn for the connections of the user older
+	// than the cursor in the same order as EachByUserId, so the walk can
+	// be resumed after the last handled connection, the zero cursor
+	// walks all the connections
+	EachByUserIdAfter(userId string, after Cursor, fn func(c Connection) error) error
+
+	// CountByUserId returns the number of connections of the user
+	CountByUserId(userId string) (int, error)
+
+	// GetByTokenId returns all connections opened with the token
+	GetByTokenId(tokenId string) ([]Connection, error)
+
+	// Touch records activity of the connection, returns ErrNotFound
+	// if the connection is gone, the activity doesn't extend ExpiresAt
+	// as the record expires with the session and the connections with
+	// no session are closed by API Gateway after 2 hours, well before
+	// MaxSession
+	Touch(connectionId string, lastSeen int64) error
+
+	// EachIdle calls fn for the connections last seen before the given
+	// epoch time, the evicted connections and the connections marked
+	// as closing since then are skipped, iteration stops at the first
+	// error returned by fn
+	EachIdle(lastSeenBefore int64, fn func(c Connection) error) error
+
+	// MarkClosing records the closing of the idle connection was
+	// requested, returns ErrNotFound if the connection is gone
+	MarkClosing(connectionId string, at int64) error
+
+	// Each calls fn for all connections including the expired ones
+	// following the connection with the given id, empty id means from
+	// the beginning, so the long walks can be resumed, the records are
+	// loaded in pages of pageSize items, 0 means the default of the
+	// store, iteration stops at the first error returned by fn
+	Each(after string, pageSize int, fn func(c Connection) error) error
 }
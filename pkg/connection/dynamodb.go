@@ -0,0 +1,594 @@
+package connection
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// DynamoDB stores connections in the given DynamoDB table, the indexes
+// are needed only for the lookups by the user and the token
+type DynamoDB struct {
+	DynamoDB     *dynamodb.DynamoDB
+	TableName    string
+	UserIdIndex  string
+	TokenIdIndex string
+
+	// UserIdIndexSorted tells the user index is sorted by Created and
+	// projects ExpiresAt, the legacy keys only index is followed by
+	// reading the records one by one
+	UserIdIndexSorted bool
+}
+
+// NewDynamoDB creates connection store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// Create implements ConnectionStore
+func (d DynamoDB) Create(c Connection) error {
+	// update time
+	c.Created = time.Now()
+	c.ExpiresAt = RecordExpiresAt(c.SessionExpiresAt)
+	c.LastSeen = c.Created.Unix()
+
+	// marshal
+	av, err := dynamodbattribute.MarshalMap(c)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(d.TableName),
+	}
+
+	_, err = d.DynamoDB.PutItem(input)
+	return err
+}
+
+// Get implements ConnectionStore
+func (d DynamoDB) Get(connectionId string) (Connection, error) {
+	c, err := d.Find(connectionId)
+	if err != nil {
+		return Connection{}, err
+	}
+
+	if c.Expired() {
+		return Connection{}, ErrNotFound
+	}
+
+	return c, nil
+}
+
+// Find implements ConnectionStore
+func (d DynamoDB) Find(connectionId string) (Connection, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(connectionId),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(d.TableName),
+	}
+
+	res, err := d.DynamoDB.GetItem(input)
+	if err != nil {
+		return Connection{}, err
+	}
+
+	if res.Item == nil {
+		return Connection{}, ErrNotFound
+	}
+
+	c := Connection{}
+	err = dynamodbattribute.UnmarshalMap(res.Item, &c)
+	if err != nil {
+		return Connection{}, err
+	}
+
+	return c, nil
+}
+
+// Authorize implements ConnectionStore, the UserId and TokenId are updated
+// as well so the connection can be notified and closed just like the
+// connections authorized on $connect
+func (d DynamoDB) Authorize(c Connection) error {
+	claims, err := dynamodbattribute.Marshal(c.Claims)
+	if err != nil {
+		return err
+	}
+
+	scopes, err := dynamodbattribute.Marshal(c.Scopes)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(c.ConnectionId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(ConnectionId)"),
+		UpdateExpression:    aws.String("SET Authorized = :a, Principal = :p, UserId = :u, TokenId = :t, Claims = :c, Scopes = :s, SessionExpiresAt = :e, ExpiresAt = :x"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":a": {
+				BOOL: aws.Bool(true),
+			},
+			":p": {
+				S: aws.String(c.Principal),
+			},
+			":u": {
+				S: aws.String(c.UserId),
+			},
+			":t": {
+				S: aws.String(c.TokenId),
+			},
+			":c": claims,
+			":s": scopes,
+			":e": {
+				N: aws.String(strconv.FormatInt(c.SessionExpiresAt, 10)),
+			},
+			":x": {
+				N: aws.String(strconv.FormatInt(RecordExpiresAt(c.SessionExpiresAt), 10)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	}
+
+	_, err = d.DynamoDB.UpdateItem(input)
+	return conditionError(err)
+}
+
+// Evict implements ConnectionStore
+func (d DynamoDB) Evict(connectionId string) error {
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(connectionId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(ConnectionId) AND attribute_not_exists(Evicted)"),
+		UpdateExpression:    aws.String("SET Evicted = :e"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":e": {
+				BOOL: aws.Bool(true),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	}
+
+	_, err := d.DynamoDB.UpdateItem(input)
+	return conditionError(err)
+}
+
+// Touch implements ConnectionStore
+func (d DynamoDB) Touch(connectionId string, lastSeen int64) error {
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(connectionId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(ConnectionId)"),
+		UpdateExpression:    aws.String("SET LastSeen = :l"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {
+				N: aws.String(strconv.FormatInt(lastSeen, 10)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	}
+
+	_, err := d.DynamoDB.UpdateItem(input)
+	return conditionError(err)
+}
+
+// MarkClosing implements ConnectionStore
+func (d DynamoDB) MarkClosing(connectionId string, at int64) error {
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(connectionId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(ConnectionId)"),
+		UpdateExpression:    aws.String("SET Closing = :c"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {
+				N: aws.String(strconv.FormatInt(at, 10)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	}
+
+	_, err := d.DynamoDB.UpdateItem(input)
+	return conditionError(err)
+}
+
+// EachIdle implements ConnectionStore, it scans the whole table so it's
+// meant for the periodic clean up only, the connections created before
+// LastSeen was recorded are idle since their creation, the closing
+// requested before lastSeenBefore was probably lost and it's requested
+// again
+func (d DynamoDB) EachIdle(lastSeenBefore int64, fn func(c Connection) error) error {
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {
+				N: aws.String(strconv.FormatInt(lastSeenBefore, 10)),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+			},
+		},
+		FilterExpression: aws.String("(LastSeen < :t OR (attribute_not_exists(LastSeen) AND Created < :t)) AND (attribute_not_exists(ExpiresAt) OR ExpiresAt > :now) AND attribute_not_exists(Evicted) AND (attribute_not_exists(Closing) OR Closing < :t)"),
+		TableName:        aws.String(d.TableName),
+	}
+
+	// go through the items page by page, the error of the
+	// callback stops the iteration
+	var fnErr error
+	err := d.DynamoDB.ScanPages(input, func(res *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range res.Items {
+			c := Connection{}
+			fnErr = dynamodbattribute.UnmarshalMap(item, &c)
+			if fnErr != nil {
+				return false
+			}
+
+			fnErr = fn(c)
+			if fnErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// Each implements ConnectionStore, it scans the whole table so it's meant
+// for the periodic maintenance only, small pages spread the consumed
+// capacity over time
+func (d DynamoDB) Each(after string, pageSize int, fn func(c Connection) error) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(d.TableName),
+	}
+	if pageSize > 0 {
+		input.Limit = aws.Int64(int64(pageSize))
+	}
+
+	// the scan continues after the position of the key even
+	// if the item was deleted meanwhile
+	if after != "" {
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(after),
+			},
+		}
+	}
+
+	// go through the items page by page, the error of the
+	// callback stops the iteration
+	var fnErr error
+	err := d.DynamoDB.ScanPages(input, func(res *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range res.Items {
+			c := Connection{}
+			fnErr = dynamodbattribute.UnmarshalMap(item, &c)
+			if fnErr != nil {
+				return false
+			}
+
+			fnErr = fn(c)
+			if fnErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// Delete implements ConnectionStore
+func (d DynamoDB) Delete(connectionId string) error {
+	input := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(connectionId),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	}
+
+	_, err := d.DynamoDB.DeleteItem(input)
+	return err
+}
+
+// Remove implements ConnectionStore
+func (d DynamoDB) Remove(connectionId string) (Connection, error) {
+	input := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionId": {
+				S: aws.String(connectionId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(ConnectionId)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+		TableName:           aws.String(d.TableName),
+	}
+
+	res, err := d.DynamoDB.DeleteItem(input)
+	if err != nil {
+		return Connection{}, conditionError(err)
+	}
+
+	c := Connection{}
+	err = dynamodbattribute.UnmarshalMap(res.Attributes, &c)
+	if err != nil {
+		return Connection{}, err
+	}
+
+	return c, nil
+}
+
+// GetByUserId implements ConnectionStore
+func (d DynamoDB) GetByUserId(userId string) ([]Connection, error) {
+	return d.GetRecentByUserId(userId, 0)
+}
+
+// GetRecentByUserId implements ConnectionStore
+func (d DynamoDB) GetRecentByUserId(userId string, limit int) ([]Connection, error) {
+	connections := []Connection{}
+	err := d.EachByUserId(userId, limit, func(c Connection) error {
+		connections = append(connections, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// EachByUserId implements ConnectionStore
+func (d DynamoDB) EachByUserId(userId string, limit int, fn func(c Connection) error) error {
+	if !d.UserIdIndexSorted {
+		connections, err := d.legacyByUserId(userId)
+		if err != nil {
+			return err
+		}
+
+		for i, c := range connections {
+			if limit > 0 && i >= limit {
+				break
+			}
+
+			err = fn(c)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return d.query(d.UserIdIndex, "UserId", userId, limit, true, nil, fn)
+}
+
+// EachByUserIdAfter implements ConnectionStore, the sorted index is
+// queried from the cursor, the legacy index has to be loaded whole
+func (d DynamoDB) EachByUserIdAfter(userId string, after Cursor, fn func(c Connection) error) error {
+	if !d.UserIdIndexSorted {
+		connections, err := d.legacyByUserId(userId)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range connections {
+			if !after.precedes(c) {
+				continue
+			}
+
+			err = fn(c)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// the start key of the index consists of the index key
+	// and the key of the table
+	var start map[string]*dynamodb.AttributeValue
+	if !after.IsZero() {
+		start = map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+			"Created": {
+				N: aws.String(strconv.FormatInt(after.Created, 10)),
+			},
+			"ConnectionId": {
+				S: aws.String(after.ConnectionId),
+			},
+		}
+	}
+
+	return d.query(d.UserIdIndex, "UserId", userId, 0, true, start, fn)
+}
+
+// CountByUserId implements ConnectionStore
+func (d DynamoDB) CountByUserId(userId string) (int, error) {
+	if !d.UserIdIndexSorted {
+		connections, err := d.legacyByUserId(userId)
+		return len(connections), err
+	}
+
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(userId),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+			},
+		},
+		KeyConditionExpression: aws.String("UserId = :v1"),
+		FilterExpression:       aws.String("(attribute_not_exists(ExpiresAt) OR ExpiresAt > :now) AND attribute_not_exists(Evicted)"),
+		Select:                 aws.String(dynamodb.SelectCount),
+		TableName:              aws.String(d.TableName),
+		IndexName:              aws.String(d.UserIdIndex),
+	}
+
+	// count the items page by page
+	count := 0
+	err := d.DynamoDB.QueryPages(input, func(res *dynamodb.QueryOutput, _ bool) bool {
+		count += int(aws.Int64Value(res.Count))
+		return true
+	})
+
+	return count, err
+}
+
+// GetByTokenId implements ConnectionStore, the token index projects only
+// the keys so the expired records can't be filtered out, closing them
+// once more is harmless
+func (d DynamoDB) GetByTokenId(tokenId string) ([]Connection, error) {
+	connections := []Connection{}
+	err := d.query(d.TokenIdIndex, "TokenId", tokenId, 0, false, nil, func(c Connection) error {
+		connections = append(connections, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// legacyByUserId loads the connections of the user through the legacy
+// index which has neither the sort key nor ExpiresAt, the records are
+// read one by one so the expired and evicted ones can be skipped and
+// sorted here, the newest first
+func (d DynamoDB) legacyByUserId(userId string) ([]Connection, error) {
+	ids := []string{}
+	err := d.query(d.UserIdIndex, "UserId", userId, 0, false, nil, func(c Connection) error {
+		ids = append(ids, c.ConnectionId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	connections := []Connection{}
+	for _, id := range ids {
+		c, err := d.Get(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if c.Evicted {
+			continue
+		}
+
+		connections = append(connections, c)
+	}
+
+	sortNewestFirst(connections)
+
+	return connections, nil
+}
+
+// query calls fn for the connections with the given value of the index
+// key, it follows all the pages unless the limit is reached, the index
+// is sorted by Created so the newest connections come first, the records
+// expired but not yet deleted by DynamoDB TTL and the evicted connections
+// are skipped unless the index doesn't project ExpiresAt and Evicted, the
+// query continues after the start key if given
+func (d DynamoDB) query(index string, key string, value string, limit int, filter bool, start map[string]*dynamodb.AttributeValue, fn func(c Connection) error) error {
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(value),
+			},
+		},
+		KeyConditionExpression: aws.String(key + " = :v1"),
+		ScanIndexForward:       aws.Bool(false),
+		TableName:              aws.String(d.TableName),
+		IndexName:              aws.String(index),
+		ExclusiveStartKey:      start,
+	}
+	if filter {
+		input.ExpressionAttributeValues[":now"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+		}
+		input.FilterExpression = aws.String("(attribute_not_exists(ExpiresAt) OR ExpiresAt > :now) AND attribute_not_exists(Evicted)")
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
+	}
+
+	// go through the items page by page, the error of the
+	// callback stops the iteration
+	count := 0
+	var fnErr error
+	err := d.DynamoDB.QueryPages(input, func(res *dynamodb.QueryOutput, _ bool) bool {
+		for _, item := range res.Items {
+			c := Connection{}
+			fnErr = dynamodbattribute.UnmarshalMap(item, &c)
+			if fnErr != nil {
+				return false
+			}
+
+			fnErr = fn(c)
+			if fnErr != nil {
+				return false
+			}
+
+			count++
+			if limit > 0 && count >= limit {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// conditionError translates failed existence condition to ErrNotFound
+func conditionError(err error) error {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,278 @@
+package connection
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory stores connections in memory, it's safe for concurrent use
+type Memory struct {
+	mu          sync.Mutex
+	connections map[string]Connection
+}
+
+// NewMemory creates an empty in-memory connection store
+func NewMemory() *Memory {
+	return &Memory{
+		connections: map[string]Connection{},
+	}
+}
+
+// Create implements ConnectionStore
+func (m *Memory) Create(c Connection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c.Created = time.Now()
+	c.ExpiresAt = RecordExpiresAt(c.SessionExpiresAt)
+	c.LastSeen = c.Created.Unix()
+	m.connections[c.ConnectionId] = c
+	return nil
+}
+
+// Get implements ConnectionStore
+func (m *Memory) Get(connectionId string) (Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.connections[connectionId]
+	if !ok || c.Expired() {
+		return Connection{}, ErrNotFound
+	}
+
+	return c, nil
+}
+
+// Find implements ConnectionStore
+func (m *Memory) Find(connectionId string) (Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.connections[connectionId]
+	if !ok {
+		return Connection{}, ErrNotFound
+	}
+
+	return c, nil
+}
+
+// Authorize implements ConnectionStore
+func (m *Memory) Authorize(c Connection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.connections[c.ConnectionId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	current.Authorized = true
+	current.Principal = c.Principal
+	current.UserId = c.UserId
+	current.TokenId = c.TokenId
+	current.Claims = c.Claims
+	current.Scopes = c.Scopes
+	current.SessionExpiresAt = c.SessionExpiresAt
+	current.ExpiresAt = RecordExpiresAt(c.SessionExpiresAt)
+	m.connections[c.ConnectionId] = current
+	return nil
+}
+
+// Evict implements ConnectionStore
+func (m *Memory) Evict(connectionId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.connections[connectionId]
+	if !ok || current.Evicted {
+		return ErrNotFound
+	}
+
+	current.Evicted = true
+	m.connections[connectionId] = current
+	return nil
+}
+
+// Touch implements ConnectionStore
+func (m *Memory) Touch(connectionId string, lastSeen int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.connections[connectionId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	current.LastSeen = lastSeen
+	m.connections[connectionId] = current
+	return nil
+}
+
+// EachIdle implements ConnectionStore, the connections created before
+// LastSeen was recorded are idle since their creation
+func (m *Memory) EachIdle(lastSeenBefore int64, fn func(c Connection) error) error {
+	connections := m.filter(func(c Connection) bool {
+		if c.Evicted || c.Closing >= lastSeenBefore {
+			return false
+		}
+
+		if c.LastSeen == 0 {
+			return c.Created.Unix() < lastSeenBefore
+		}
+
+		return c.LastSeen < lastSeenBefore
+	})
+
+	for _, c := range connections {
+		err := fn(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkClosing implements ConnectionStore
+func (m *Memory) MarkClosing(connectionId string, at int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.connections[connectionId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	current.Closing = at
+	m.connections[connectionId] = current
+	return nil
+}
+
+// Each implements ConnectionStore, the connections are walked in the
+// order of their ids
+func (m *Memory) Each(after string, _ int, fn func(c Connection) error) error {
+	m.mu.Lock()
+	connections := []Connection{}
+	for _, c := range m.connections {
+		if c.ConnectionId > after {
+			connections = append(connections, c)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectionId < connections[j].ConnectionId
+	})
+
+	for _, c := range connections {
+		err := fn(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete implements ConnectionStore
+func (m *Memory) Delete(connectionId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.connections, connectionId)
+	return nil
+}
+
+// Remove implements ConnectionStore
+func (m *Memory) Remove(connectionId string) (Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.connections[connectionId]
+	if !ok {
+		return Connection{}, ErrNotFound
+	}
+
+	delete(m.connections, connectionId)
+	return c, nil
+}
+
+// GetByUserId implements ConnectionStore
+func (m *Memory) GetByUserId(userId string) ([]Connection, error) {
+	return m.GetRecentByUserId(userId, 0)
+}
+
+// GetRecentByUserId implements ConnectionStore
+func (m *Memory) GetRecentByUserId(userId string, limit int) ([]Connection, error) {
+	connections := m.filter(func(c Connection) bool {
+		return c.UserId == userId && !c.Evicted
+	})
+
+	// newest first
+	sortNewestFirst(connections)
+
+	if limit > 0 && len(connections) > limit {
+		connections = connections[:limit]
+	}
+
+	return connections, nil
+}
+
+// EachByUserId implements ConnectionStore
+func (m *Memory) EachByUserId(userId string, limit int, fn func(c Connection) error) error {
+	connections, _ := m.GetRecentByUserId(userId, limit)
+	for _, c := range connections {
+		err := fn(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EachByUserIdAfter implements ConnectionStore
+func (m *Memory) EachByUserIdAfter(userId string, after Cursor, fn func(c Connection) error) error {
+	connections, _ := m.GetByUserId(userId)
+	for _, c := range connections {
+		if !after.precedes(c) {
+			continue
+		}
+
+		err := fn(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountByUserId implements ConnectionStore
+func (m *Memory) CountByUserId(userId string) (int, error) {
+	connections, _ := m.GetByUserId(userId)
+	return len(connections), nil
+}
+
+// GetByTokenId implements ConnectionStore
+func (m *Memory) GetByTokenId(tokenId string) ([]Connection, error) {
+	return m.filter(func(c Connection) bool {
+		return c.TokenId == tokenId
+	}), nil
+}
+
+// filter returns the connections matching the predicate
+func (m *Memory) filter(match func(c Connection) bool) []Connection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connections := []Connection{}
+	for _, c := range m.connections {
+		if match(c) && !c.Expired() {
+			connections = append(connections, c)
+		}
+	}
+
+	return connections
+}
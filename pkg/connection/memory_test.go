@@ -0,0 +1,143 @@
+package connection
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func idle(t *testing.T, m *Memory, lastSeenBefore int64) []string {
+	t.Helper()
+
+	ids := []string{}
+	err := m.EachIdle(lastSeenBefore, func(c Connection) error {
+		ids = append(ids, c.ConnectionId)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func TestMemoryEachIdle(t *testing.T) {
+	m := NewMemory()
+	now := time.Now().Unix()
+
+	for _, id := range []string{"active", "idle", "legacy", "expired"} {
+		c := New(id, "1234")
+		c.SessionExpiresAt = now + 3600
+		if id == "expired" {
+			c.SessionExpiresAt = now - 3600
+		}
+		if err := m.Create(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = m.Touch("active", now)
+	_ = m.Touch("idle", now-600)
+	_ = m.Touch("expired", now-600)
+
+	// the record written before LastSeen was recorded
+	_ = m.Touch("legacy", 0)
+
+	if ids := idle(t, m, now-300); !reflect.DeepEqual(ids, []string{"idle"}) {
+		t.Fatalf("expected idle connection, got %v", ids)
+	}
+
+	// the connections without LastSeen are idle since their creation
+	if ids := idle(t, m, now+1); !reflect.DeepEqual(ids, []string{"active", "idle", "legacy"}) {
+		t.Fatalf("expected all unexpired connections, got %v", ids)
+	}
+}
+
+func TestMemoryTouch(t *testing.T) {
+	m := NewMemory()
+
+	c := New("c1", "1234")
+	c.SessionExpiresAt = time.Now().Add(time.Hour).Unix()
+	if err := m.Create(c); err != nil {
+		t.Fatal(err)
+	}
+
+	created, _ := m.Find("c1")
+	if err := m.Touch("c1", time.Now().Add(time.Minute).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the activity doesn't extend the record beyond the session
+	touched, _ := m.Find("c1")
+	if touched.ExpiresAt != created.ExpiresAt {
+		t.Fatalf("expected expiration %d, got %d", created.ExpiresAt, touched.ExpiresAt)
+	}
+
+	if err := m.Touch("unknown", time.Now().Unix()); err != ErrNotFound {
+		t.Fatalf("expected error %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemoryByUserIdSkipsEvicted(t *testing.T) {
+	m := NewMemory()
+
+	for _, id := range []string{"c1", "c2", "c3"} {
+		c := New(id, "1234")
+		c.SessionExpiresAt = time.Now().Add(time.Hour).Unix()
+		if err := m.Create(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Evict("c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, _ := m.CountByUserId("1234"); count != 2 {
+		t.Fatalf("expected 2 connections, got %d", count)
+	}
+
+	ids := []string{}
+	_ = m.EachByUserId("1234", 0, func(c Connection) error {
+		ids = append(ids, c.ConnectionId)
+		return nil
+	})
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"c2", "c3"}) {
+		t.Fatalf("expected connections c2 and c3, got %v", ids)
+	}
+}
+
+func TestMemoryEachByUserIdAfter(t *testing.T) {
+	m := NewMemory()
+
+	for _, id := range []string{"c1", "c2", "c3", "c4"} {
+		if err := m.Create(New(id, "1234")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// walk the connections two at a time
+	ids := []string{}
+	after := Cursor{}
+	for i := 0; i < 3; i++ {
+		count := 0
+		_ = m.EachByUserIdAfter("1234", after, func(c Connection) error {
+			if count == 2 {
+				return errors.New("page is full")
+			}
+			count++
+
+			ids = append(ids, c.ConnectionId)
+			after = c.Cursor()
+			return nil
+		})
+	}
+
+	if !reflect.DeepEqual(ids, []string{"c4", "c3", "c2", "c1"}) {
+		t.Fatalf("expected all connections once from the newest, got %v", ids)
+	}
+}
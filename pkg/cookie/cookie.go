@@ -0,0 +1,102 @@
+package cookie
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed session cookie")
+	ErrInvalidSignature = errors.New("invalid session cookie signature")
+	ErrExpired          = errors.New("session cookie expired")
+)
+
+// Session is the payload of the signed session cookie set by the web
+// application, the cookie value is base64url encoded json payload and
+// its HMAC-SHA256 signature separated by a dot
+type Session struct {
+	UserId    string `json:"userId"`
+	Scope     string `json:"scope,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+
+	// IssuedAt is checked against the revocations of the user, sessions
+	// without it are rejected once the user is logged out
+	IssuedAt int64 `json:"iat,omitempty"`
+}
+
+// Sign encodes the session to the signed cookie value
+func Sign(s Session, secret []byte) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("could not encode session: %s", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac(payload, secret)), nil
+}
+
+// Verify checks the signature and the expiration of the cookie value
+// and returns the session
+func Verify(value string, secret []byte) (Session, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return Session{}, ErrMalformed
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return Session{}, ErrMalformed
+	}
+
+	if !hmac.Equal(sig, mac(payload, secret)) {
+		return Session{}, ErrInvalidSignature
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Session{}, ErrMalformed
+	}
+
+	s := Session{}
+	err = json.Unmarshal(data, &s)
+	if err != nil || s.UserId == "" {
+		return Session{}, ErrMalformed
+	}
+
+	if time.Now().Unix() >= s.ExpiresAt {
+		return Session{}, ErrExpired
+	}
+
+	return s, nil
+}
+
+// Find returns value of the named cookie, both the cookies list of
+// the payload version 2.0 and the Cookie header are searched
+func Find(name string, cookies []string, headers map[string]string) (string, bool) {
+	for header, value := range headers {
+		if strings.EqualFold(header, "cookie") {
+			cookies = append(cookies, strings.Split(value, ";")...)
+		}
+	}
+
+	for _, c := range cookies {
+		n, v, ok := strings.Cut(strings.TrimSpace(c), "=")
+		if ok && n == name {
+			return strings.Trim(v, `"`), true
+		}
+	}
+
+	return "", false
+}
+
+func mac(payload string, secret []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
@@ -0,0 +1,141 @@
+package cookie
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	session := Session{
+		UserId:    "1234",
+		Scope:     "ping",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		IssuedAt:  time.Now().Unix(),
+	}
+
+	sign := func(s Session, secret []byte) string {
+		value, err := Sign(s, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	valid := sign(session, secret)
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{
+			name:  "valid",
+			value: valid,
+		},
+		{
+			name: "tampered payload",
+			value: func() string {
+				tampered := session
+				tampered.UserId = "admin"
+				p, _, _ := strings.Cut(sign(tampered, secret), ".")
+				return p + "." + signature
+			}(),
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "tampered signature",
+			value: func() string {
+				sig, _ := base64.RawURLEncoding.DecodeString(signature)
+				sig[0] ^= 1
+				return payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+			}(),
+			err: ErrInvalidSignature,
+		},
+		{
+			name:  "wrong secret",
+			value: sign(session, []byte(strings.Repeat("o", 32))),
+			err:   ErrInvalidSignature,
+		},
+		{
+			name:  "missing signature",
+			value: payload + ".",
+			err:   ErrInvalidSignature,
+		},
+		{
+			name: "expired",
+			value: func() string {
+				expired := session
+				expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+				return sign(expired, secret)
+			}(),
+			err: ErrExpired,
+		},
+		{
+			name: "missing user",
+			value: func() string {
+				anonymous := session
+				anonymous.UserId = ""
+				return sign(anonymous, secret)
+			}(),
+			err: ErrMalformed,
+		},
+		{
+			name:  "malformed",
+			value: payload,
+			err:   ErrMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Verify(tt.value, secret)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err == nil && s != session {
+				t.Fatalf("expected session %+v, got %+v", session, s)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookies []string
+		headers map[string]string
+		value   string
+		found   bool
+	}{
+		{
+			name:    "cookies list",
+			cookies: []string{"other=1", "session=value"},
+			value:   "value",
+			found:   true,
+		},
+		{
+			name:    "cookie header",
+			headers: map[string]string{"Cookie": "other=1; session=\"value\""},
+			value:   "value",
+			found:   true,
+		},
+		{
+			name:    "missing",
+			cookies: []string{"other=1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, found := Find("session", tt.cookies, tt.headers)
+			if value != tt.value || found != tt.found {
+				t.Fatalf("expected %q %v, got %q %v", tt.value, tt.found, value, found)
+			}
+		})
+	}
+}
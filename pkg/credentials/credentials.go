@@ -0,0 +1,104 @@
+package credentials
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Credentials are provided by the caller asking for a token
+type Credentials struct {
+	UserId string `json:"userId"`
+	Secret string `json:"secret"`
+}
+
+// FromString decodes json to Credentials
+func FromString(credentials string) (Credentials, error) {
+	c := Credentials{}
+	err := json.Unmarshal([]byte(credentials), &c)
+	return c, err
+}
+
+// Verifier checks whether the credentials belong to the user and returns
+// the scopes granted to the user, it returns ErrInvalidCredentials if the
+// credentials don't match
+type Verifier interface {
+	Verify(ctx context.Context, credentials Credentials) ([]string, error)
+}
+
+// Static verifies credentials against a fixed set of SHA-256 digests
+// of user secrets
+type Static struct {
+	users map[string]staticUser
+}
+
+type staticUser struct {
+	digest []byte
+	scopes []string
+}
+
+// staticUserConfig is the configuration of a single user, the plain
+// string with the digest is accepted as well for users without scopes
+type staticUserConfig struct {
+	Secret string   `json:"secret"`
+	Scopes []string `json:"scopes"`
+}
+
+// NewStaticFromString creates Static verifier from json object mapping
+// userId to the hex encoded SHA-256 digest of the user's secret and
+// the scopes granted to the user
+//
+//	{"1234": {"secret": "9f86d0...", "scopes": ["ping"]}}
+func NewStaticFromString(config string) (Static, error) {
+	encoded := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(config), &encoded)
+	if err != nil {
+		return Static{}, fmt.Errorf("could not decode credentials: %s", err)
+	}
+
+	users := map[string]staticUser{}
+	for userId, raw := range encoded {
+		c := staticUserConfig{}
+		if json.Unmarshal(raw, &c.Secret) != nil {
+			err = json.Unmarshal(raw, &c)
+			if err != nil {
+				return Static{}, fmt.Errorf("could not decode credentials of user %s: %s", userId, err)
+			}
+		}
+
+		d, err := hex.DecodeString(c.Secret)
+		if err != nil || len(d) != sha256.Size {
+			return Static{}, fmt.Errorf("invalid secret digest for user %s", userId)
+		}
+
+		users[userId] = staticUser{
+			digest: d,
+			scopes: c.Scopes,
+		}
+	}
+
+	return Static{
+		users: users,
+	}, nil
+}
+
+// Verify implements Verifier
+func (s Static) Verify(_ context.Context, credentials Credentials) ([]string, error) {
+	user, ok := s.users[credentials.UserId]
+	if !ok || credentials.Secret == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	digest := sha256.Sum256([]byte(credentials.Secret))
+	if subtle.ConstantTimeCompare(digest[:], user.digest) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user.scopes, nil
+}
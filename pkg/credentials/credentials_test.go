@@ -0,0 +1,99 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// SHA-256 digest of "test"
+const digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func TestStaticVerify(t *testing.T) {
+	s, err := NewStaticFromString(`{
+		"1234": {"secret": "` + digest + `", "scopes": ["ping", "admin"]},
+		"5678": "` + digest + `"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		credentials Credentials
+		scopes      []string
+		err         error
+	}{
+		{
+			name:        "valid",
+			credentials: Credentials{UserId: "1234", Secret: "test"},
+			scopes:      []string{"ping", "admin"},
+		},
+		{
+			name:        "valid without scopes",
+			credentials: Credentials{UserId: "5678", Secret: "test"},
+		},
+		{
+			name:        "wrong secret",
+			credentials: Credentials{UserId: "1234", Secret: "wrong"},
+			err:         ErrInvalidCredentials,
+		},
+		{
+			name:        "digest as secret",
+			credentials: Credentials{UserId: "1234", Secret: digest},
+			err:         ErrInvalidCredentials,
+		},
+		{
+			name:        "empty secret",
+			credentials: Credentials{UserId: "1234"},
+			err:         ErrInvalidCredentials,
+		},
+		{
+			name:        "unknown user",
+			credentials: Credentials{UserId: "0000", Secret: "test"},
+			err:         ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, err := s.Verify(context.Background(), tt.credentials)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(scopes, tt.scopes) {
+				t.Fatalf("expected scopes %v, got %v", tt.scopes, scopes)
+			}
+		})
+	}
+}
+
+func TestNewStaticFromString(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "invalid json",
+			config: `{"1234": `,
+		},
+		{
+			name:   "plain secret instead of digest",
+			config: `{"1234": "test"}`,
+		},
+		{
+			name:   "short digest",
+			config: `{"1234": {"secret": "9f86d081"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStaticFromString(tt.config)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
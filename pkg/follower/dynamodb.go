@@ -0,0 +1,86 @@
+package follower
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDB stores the followers in the given DynamoDB table, the table
+// is keyed by the followed UserId and the FollowerId
+type DynamoDB struct {
+	DynamoDB  *dynamodb.DynamoDB
+	TableName string
+}
+
+// NewDynamoDB creates follower store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// Follow implements Store
+func (d DynamoDB) Follow(userId string, followerId string) error {
+	_, err := d.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		Item:      d.key(userId, followerId),
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// Unfollow implements Store
+func (d DynamoDB) Unfollow(userId string, followerId string) error {
+	_, err := d.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:       d.key(userId, followerId),
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// EachFollower implements Store
+func (d DynamoDB) EachFollower(userId string, fn func(followerId string) error) error {
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(userId),
+			},
+		},
+		KeyConditionExpression: aws.String("UserId = :v1"),
+		TableName:              aws.String(d.TableName),
+	}
+
+	// go through the items page by page, the error of the
+	// callback stops the iteration
+	var fnErr error
+	err := d.DynamoDB.QueryPages(input, func(res *dynamodb.QueryOutput, _ bool) bool {
+		for _, item := range res.Items {
+			if item["FollowerId"] == nil {
+				continue
+			}
+
+			fnErr = fn(aws.StringValue(item["FollowerId"].S))
+			if fnErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+func (d DynamoDB) key(userId string, followerId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"UserId": {
+			S: aws.String(userId),
+		},
+		"FollowerId": {
+			S: aws.String(followerId),
+		},
+	}
+}
@@ -0,0 +1,11 @@
+package follower
+
+// Store keeps the users following presence of other users
+type Store interface {
+	Follow(userId string, followerId string) error
+	Unfollow(userId string, followerId string) error
+
+	// EachFollower calls fn for the followers of the user as they are
+	// loaded, iteration stops at the first error returned by fn
+	EachFollower(userId string, fn func(followerId string) error) error
+}
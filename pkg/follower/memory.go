@@ -0,0 +1,62 @@
+package follower
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory stores the followers in memory, it's safe for concurrent use
+type Memory struct {
+	mu        sync.Mutex
+	followers map[string]map[string]bool
+}
+
+// NewMemory creates an empty in-memory follower store
+func NewMemory() *Memory {
+	return &Memory{
+		followers: map[string]map[string]bool{},
+	}
+}
+
+// Follow implements Store
+func (m *Memory) Follow(userId string, followerId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.followers[userId] == nil {
+		m.followers[userId] = map[string]bool{}
+	}
+	m.followers[userId][followerId] = true
+	return nil
+}
+
+// Unfollow implements Store
+func (m *Memory) Unfollow(userId string, followerId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.followers[userId], followerId)
+	return nil
+}
+
+// EachFollower implements Store
+func (m *Memory) EachFollower(userId string, fn func(followerId string) error) error {
+	m.mu.Lock()
+	followers := []string{}
+	for followerId := range m.followers[userId] {
+		followers = append(followers, followerId)
+	}
+	m.mu.Unlock()
+
+	// the same order as the DynamoDB sort key
+	sort.Strings(followers)
+
+	for _, followerId := range followers {
+		err := fn(followerId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
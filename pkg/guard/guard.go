@@ -0,0 +1,119 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"github.com/pipetail/sst-websocket/pkg/token"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// Dependencies are needed to look up the connection and to notify it
+// about the rejection
+type Dependencies struct {
+	Logger      *zap.Logger
+	Connections connection.ConnectionStore
+	SQS         sqsiface.SQSAPI
+	SQSURL      string
+}
+
+// Authorized lets through only messages from authorized connections,
+// the connection record is available to the next handler through
+// ConnectionFromContext
+func Authorized(d Dependencies, next apigw.WebsocketHandler) apigw.WebsocketHandler {
+	return func(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
+		connectionId := req.RequestContext.ConnectionID
+
+		c, err := d.Connections.Get(connectionId)
+		if err != nil && !errors.Is(err, connection.ErrNotFound) {
+			d.Logger.Error("could not get connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		if err != nil || !c.Authorized {
+			d.Logger.Info("rejecting message from unauthorized connection",
+				zap.String("connectionId", connectionId),
+				zap.String("routeKey", req.RequestContext.RouteKey),
+			)
+			return Reply(d, connectionId, notification.ErrorFrame("unauthorized", "send the authorize action first"), apigw.UnauthorizedResponse())
+		}
+
+		// record the activity so the connection is not reaped as idle,
+		// the message is processed even if the record can't be updated
+		err = d.Connections.Touch(connectionId, time.Now().Unix())
+		if err != nil {
+			d.Logger.Warn("could not record activity of connection",
+				zap.String("connectionId", connectionId),
+				zap.Error(err),
+			)
+		}
+
+		return next(context.WithValue(ctx, contextKey{}, c), req)
+	}
+}
+
+// RequireScopes lets through only messages from connections granted all
+// the required scopes, it has to be wrapped by Authorized
+func RequireScopes(d Dependencies, scopes []string, next apigw.WebsocketHandler) apigw.WebsocketHandler {
+	return func(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
+		connectionId := req.RequestContext.ConnectionID
+
+		c, ok := ConnectionFromContext(ctx)
+		if !ok {
+			return apigw.InternalServerErrorResponse(), errors.New("connection is not in the context, the handler is not wrapped by Authorized")
+		}
+
+		for _, scope := range scopes {
+			if token.HasScopes(c.Scopes, scope) {
+				continue
+			}
+
+			d.Logger.Info("rejecting message from connection without required scope",
+				zap.String("connectionId", connectionId),
+				zap.String("routeKey", req.RequestContext.RouteKey),
+				zap.String("scope", scope),
+			)
+			return Reply(d, connectionId, notification.ErrorFrame("forbidden", "missing scope: "+scope), apigw.ForbiddenResponse())
+		}
+
+		return next(ctx, req)
+	}
+}
+
+// ConnectionFromContext returns the connection record loaded by the guard
+func ConnectionFromContext(ctx context.Context) (connection.Connection, bool) {
+	c, ok := ctx.Value(contextKey{}).(connection.Connection)
+	return c, ok
+}
+
+// Reply sends the frame into the connection through the notify connection
+// queue and returns the given response, it tells the client whether its
+// message was processed as the response of the route is not delivered
+func Reply(d Dependencies, connectionId string, frame notification.Frame, res apigw.Response) (apigw.Response, error) {
+	n := notification.ConnectionNotification{
+		ConnectionId: connectionId,
+		Data:         frame.String(),
+	}
+
+	err := n.NotifySQS(d.SQS, d.SQSURL)
+	if err != nil {
+		d.Logger.Error("could not notify the connection",
+			zap.String("connectionId", connectionId),
+			zap.Error(err),
+		)
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	return res, nil
+}
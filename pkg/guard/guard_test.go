@@ -0,0 +1,150 @@
+package guard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	apigw "github.com/pipetail/sst-websocket/pkg/apigateway"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/notification"
+	"go.uber.org/zap"
+)
+
+const notifyQueue = "notify"
+
+// failingTouch is the store which can't record the activity
+type failingTouch struct {
+	*connection.Memory
+}
+
+func (failingTouch) Touch(string, int64) error {
+	return errors.New("throttled")
+}
+
+// replies returns the frames sent back to the connection
+func replies(t *testing.T, s *awstest.SQS) []notification.Frame {
+	t.Helper()
+
+	frames := []notification.Frame{}
+	for _, m := range s.Messages(notifyQueue) {
+		n, err := notification.ConnectionFromString(aws.StringValue(m.MessageBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f := notification.Frame{}
+		if err := json.Unmarshal([]byte(n.Data), &f); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+
+	return frames
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection *connection.Connection
+		scopes     []string
+		touchFails bool
+		status     int
+		code       string
+	}{
+		{
+			name:       "authorized",
+			connection: &connection.Connection{ConnectionId: "c1", UserId: "1234", Authorized: true, Scopes: []string{"ping"}},
+			scopes:     []string{"ping"},
+			status:     http.StatusOK,
+		},
+		{
+			name:       "anonymous connection",
+			connection: &connection.Connection{ConnectionId: "c1"},
+			status:     http.StatusUnauthorized,
+			code:       "unauthorized",
+		},
+		{
+			name:   "unknown connection",
+			status: http.StatusUnauthorized,
+			code:   "unauthorized",
+		},
+		{
+			name:       "missing scope",
+			connection: &connection.Connection{ConnectionId: "c1", UserId: "1234", Authorized: true, Scopes: []string{"ping"}},
+			scopes:     []string{"ping", "admin"},
+			status:     http.StatusForbidden,
+			code:       "forbidden",
+		},
+		{
+			name:       "activity not recorded",
+			connection: &connection.Connection{ConnectionId: "c1", UserId: "1234", Authorized: true},
+			touchFails: true,
+			status:     http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connections := connection.NewMemory()
+			s := awstest.NewSQS()
+			d := Dependencies{
+				Logger:      zap.NewNop(),
+				Connections: connections,
+				SQS:         s,
+				SQSURL:      notifyQueue,
+			}
+			if tt.touchFails {
+				d.Connections = failingTouch{connections}
+			}
+
+			if tt.connection != nil {
+				c := *tt.connection
+				c.SessionExpiresAt = time.Now().Add(time.Hour).Unix()
+				if err := connections.Create(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			called := false
+			next := func(ctx context.Context, _ *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
+				called = true
+				if c, ok := ConnectionFromContext(ctx); !ok || c.ConnectionId != "c1" {
+					t.Fatalf("expected connection in the context, got %+v", c)
+				}
+				return apigw.OkResponse(), nil
+			}
+
+			res, err := Authorized(d, RequireScopes(d, tt.scopes, next))(context.Background(), &events.APIGatewayWebsocketProxyRequest{
+				RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+					ConnectionID: "c1",
+					RouteKey:     "ping",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, res.StatusCode)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Fatalf("expected next handler called %v, got %v", tt.status == http.StatusOK, called)
+			}
+
+			// the rejected client is told why
+			frames := replies(t, s)
+			if tt.code == "" && len(frames) > 0 {
+				t.Fatalf("unexpected replies %+v", frames)
+			}
+			if tt.code != "" && (len(frames) != 1 || frames[0].Type != "error" || frames[0].Code != tt.code) {
+				t.Fatalf("expected %s error reply, got %+v", tt.code, frames)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package keystore
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// DynamoDB stores keys in the given DynamoDB table
+type DynamoDB struct {
+	DynamoDB  *dynamodb.DynamoDB
+	TableName string
+}
+
+// NewDynamoDB creates key store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// List implements Store
+func (d DynamoDB) List() ([]StoredKey, error) {
+	keys := []StoredKey{}
+	var unmarshalErr error
+
+	// the table holds just a handful of keys
+	err := d.DynamoDB.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String(d.TableName),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range page.Items {
+			k := StoredKey{}
+			unmarshalErr = dynamodbattribute.UnmarshalMap(item, &k)
+			if unmarshalErr != nil {
+				return false
+			}
+			keys = append(keys, k)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return keys, nil
+}
+
+// Put implements Store
+func (d DynamoDB) Put(key StoredKey) error {
+	av, err := dynamodbattribute.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// Delete implements Store
+func (d DynamoDB) Delete(id string) error {
+	_, err := d.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(id),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
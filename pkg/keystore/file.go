@@ -0,0 +1,104 @@
+package keystore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File stores keys in a local json file, it's meant for local
+// development and tests
+type File struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// NewFile creates key store backed by the given file, the file
+// is created on the first write
+func NewFile(path string) *File {
+	return &File{
+		Path: path,
+	}
+}
+
+// List implements Store
+func (f *File) List() ([]StoredKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.read()
+}
+
+// Put implements Store
+func (f *File) Put(key StoredKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, k := range keys {
+		if k.Id == key.Id {
+			keys[i] = key
+			replaced = true
+		}
+	}
+	if !replaced {
+		keys = append(keys, key)
+	}
+
+	return f.write(keys)
+}
+
+// Delete implements Store
+func (f *File) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	kept := []StoredKey{}
+	for _, k := range keys {
+		if k.Id != id {
+			kept = append(kept, k)
+		}
+	}
+
+	return f.write(kept)
+}
+
+func (f *File) read() ([]StoredKey, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []StoredKey{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %s", err)
+	}
+
+	keys := []StoredKey{}
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode key file: %s", err)
+	}
+
+	return keys, nil
+}
+
+func (f *File) write(keys []StoredKey) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode keys: %s", err)
+	}
+
+	// the file holds private keys
+	return os.WriteFile(f.Path, data, 0600)
+}
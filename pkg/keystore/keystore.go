@@ -0,0 +1,202 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pipetail/sst-websocket/pkg/token"
+)
+
+// key lifecycle, the next key is published and accepted by verifiers
+// but not used for signing yet so the caches can pick it up before
+// it gets promoted; the previous key is accepted until it's retired
+// so the tokens signed by it stay valid
+const (
+	StatusNext     = "next"
+	StatusCurrent  = "current"
+	StatusPrevious = "previous"
+)
+
+// CacheTTL is how long the issuer and verifiers keep the loaded keys,
+// a generated key has to be published at least this long before
+// it's promoted
+const CacheTTL = 5 * time.Minute
+
+var (
+	ErrNotFound       = errors.New("key not found")
+	ErrRetiringActive = errors.New("current key can't be retired")
+)
+
+// StoredKey is the signing key as persisted in the key store
+type StoredKey struct {
+	Id        string
+	Algorithm string
+	Material  string
+	Status    string
+	Created   time.Time
+	Promoted  time.Time
+}
+
+// Store persists signing keys
+type Store interface {
+	List() ([]StoredKey, error)
+	Put(key StoredKey) error
+	Delete(id string) error
+}
+
+// Generate creates a new key of the given algorithm and stores it
+// as the next key
+func Generate(store Store, algorithm string) (StoredKey, error) {
+	material, err := generateMaterial(algorithm)
+	if err != nil {
+		return StoredKey{}, err
+	}
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return StoredKey{}, fmt.Errorf("could not generate key id: %s", err)
+	}
+
+	k := StoredKey{
+		Id:        hex.EncodeToString(id),
+		Algorithm: algorithm,
+		Material:  material,
+		Status:    StatusNext,
+		Created:   time.Now(),
+	}
+
+	return k, store.Put(k)
+}
+
+// Promote makes the given key the signing key, the current key is
+// kept as the previous one
+func Promote(store Store, id string) error {
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	promoted, ok := find(keys, id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	// store the new current key first, readers pick the most recently
+	// promoted key so there is always a key to sign with
+	promoted.Status = StatusCurrent
+	promoted.Promoted = time.Now()
+	err = store.Put(promoted)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if k.Id == id || k.Status != StatusCurrent {
+			continue
+		}
+
+		k.Status = StatusPrevious
+		err = store.Put(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Retire removes the key from the store, tokens signed by it are
+// no longer accepted
+func Retire(store Store, id string) error {
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	k, ok := find(keys, id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	if k.Status == StatusCurrent {
+		return ErrRetiringActive
+	}
+
+	return store.Delete(id)
+}
+
+// KeySource provides keys from the store to token issuers and validators
+type KeySource struct {
+	Store Store
+}
+
+// Keys implements token.KeySource
+func (s KeySource) Keys() (token.KeySet, error) {
+	keys, err := s.Store.List()
+	if err != nil {
+		return token.KeySet{}, err
+	}
+
+	set := token.KeySet{}
+	var promoted time.Time
+
+	for _, k := range keys {
+		key, err := token.KeyFromString(k.Algorithm, k.Material)
+		if err != nil {
+			return token.KeySet{}, fmt.Errorf("could not load key %s: %s", k.Id, err)
+		}
+		key.Id = k.Id
+
+		set.Keys = append(set.Keys, key)
+
+		if k.Status == StatusCurrent && k.Promoted.After(promoted) {
+			set.SigningKeyId = k.Id
+			promoted = k.Promoted
+		}
+	}
+
+	return set, nil
+}
+
+func find(keys []StoredKey, id string) (StoredKey, bool) {
+	for _, k := range keys {
+		if k.Id == id {
+			return k, true
+		}
+	}
+
+	return StoredKey{}, false
+}
+
+// generateMaterial creates random secret for HS256 or PEM encoded
+// private key for RS256
+func generateMaterial(algorithm string) (string, error) {
+	switch algorithm {
+	case token.HS256:
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return "", fmt.Errorf("could not generate secret: %s", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(secret), nil
+	case token.RS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", fmt.Errorf("could not generate RSA key: %s", err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(private),
+		})), nil
+	default:
+		return "", fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+}
@@ -6,11 +6,17 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pipetail/sst-websocket/pkg/connection"
 )
 
 type UserNotification struct {
 	UserId string `json:"userId"`
 	Data   string `json:"data"`
+
+	// After is set when the notification is resumed, only the connections
+	// of the user after the cursor are notified
+	After *connection.Cursor `json:"after,omitempty"`
 }
 
 type ConnectionNotification struct {
@@ -32,7 +38,23 @@ func ConnectionFromString(notification string) (ConnectionNotification, error) {
 	return u, err
 }
 
-func (n ConnectionNotification) NotifySQS(sqsSvc *sqs.SQS, url string) error {
+func (n UserNotification) NotifySQS(sqsSvc sqsiface.SQSAPI, url string) error {
+	// serialize UserNotification
+	data, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("could not encode message body: %s", err)
+	}
+
+	// send message to SQS
+	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(url),
+		MessageBody: aws.String(string(data)),
+	})
+
+	return err
+}
+
+func (n ConnectionNotification) NotifySQS(sqsSvc sqsiface.SQSAPI, url string) error {
 	// serialize ConnectionNotification
 	data, err := json.Marshal(n)
 	if err != nil {
@@ -47,3 +69,58 @@ func (n ConnectionNotification) NotifySQS(sqsSvc *sqs.SQS, url string) error {
 
 	return err
 }
+
+// Frame is a structured message sent into the connection by the API itself
+type Frame struct {
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ErrorFrame creates a frame informing the client its message was rejected
+func ErrorFrame(code string, message string) Frame {
+	return Frame{
+		Type:    "error",
+		Code:    code,
+		Message: message,
+	}
+}
+
+// CloseFrame creates a frame informing the client the connection is
+// about to be closed by the API
+func CloseFrame(reason string) Frame {
+	return Frame{
+		Type: "close",
+		Code: reason,
+	}
+}
+
+// String encodes the frame to json
+func (f Frame) String() string {
+	data, _ := json.Marshal(f)
+	return string(data)
+}
+
+// PresenceFrame tells the follower that the user came online or went offline
+type PresenceFrame struct {
+	Type     string `json:"type"`
+	UserId   string `json:"userId"`
+	Online   bool   `json:"online"`
+	LastSeen int64  `json:"lastSeen,omitempty"`
+}
+
+// NewPresenceFrame creates a frame announcing the presence change
+func NewPresenceFrame(userId string, online bool, lastSeen int64) PresenceFrame {
+	return PresenceFrame{
+		Type:     "presence",
+		UserId:   userId,
+		Online:   online,
+		LastSeen: lastSeen,
+	}
+}
+
+// String encodes the frame to json
+func (f PresenceFrame) String() string {
+	data, _ := json.Marshal(f)
+	return string(data)
+}
@@ -0,0 +1,281 @@
+package presence
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDB stores the last seen times in the given DynamoDB table
+// keyed by UserId
+type DynamoDB struct {
+	DynamoDB  *dynamodb.DynamoDB
+	TableName string
+}
+
+// NewDynamoDB creates presence store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// Touch implements Store
+func (d DynamoDB) Touch(userId string, at int64) error {
+	_, err := d.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		UpdateExpression: aws.String("SET LastSeen = :t"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {
+				N: aws.String(strconv.FormatInt(at, 10)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// Published implements Store
+func (d DynamoDB) Published(userId string) (bool, error) {
+	res, err := d.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("Online"),
+		TableName:            aws.String(d.TableName),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if res.Item == nil || res.Item["Online"] == nil {
+		return false, nil
+	}
+
+	return aws.BoolValue(res.Item["Online"].BOOL), nil
+}
+
+// Publish implements Store
+func (d DynamoDB) Publish(userId string, online bool) (bool, error) {
+	// users without the attribute were never announced online
+	condition := "attribute_exists(Online) AND Online <> :o"
+	if online {
+		condition = "attribute_not_exists(Online) OR Online <> :o"
+	}
+
+	_, err := d.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression: aws.String(condition),
+		UpdateExpression:    aws.String("SET Online = :o"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {
+				BOOL: aws.Bool(online),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Acquire implements Store
+func (d DynamoDB) Acquire(userId string, max int) (bool, error) {
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		UpdateExpression: aws.String("ADD Connections :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {
+				N: aws.String("1"),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	}
+
+	// the condition keeps the limit under concurrent connects
+	if max > 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(Connections) OR Connections < :max")
+		input.ExpressionAttributeValues[":max"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.Itoa(max)),
+		}
+	}
+
+	_, err := d.DynamoDB.UpdateItem(input)
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Release implements Store
+func (d DynamoDB) Release(userId string) error {
+	_, err := d.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression: aws.String("Connections > :zero"),
+		UpdateExpression:    aws.String("ADD Connections :minus"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":zero": {
+				N: aws.String("0"),
+			},
+			":minus": {
+				N: aws.String("-1"),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+
+	// the counter never goes below zero
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+
+	return err
+}
+
+// Connections implements Store
+func (d DynamoDB) Connections(userId string) (int, error) {
+	res, err := d.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("Connections"),
+		TableName:            aws.String(d.TableName),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if res.Item == nil || res.Item["Connections"] == nil {
+		return 0, nil
+	}
+
+	return strconv.Atoi(aws.StringValue(res.Item["Connections"].N))
+}
+
+// ResetConnections implements Store
+func (d DynamoDB) ResetConnections(userId string, from int, to int) (bool, error) {
+	_, err := d.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression: aws.String("Connections = :from"),
+		UpdateExpression:    aws.String("SET Connections = :to"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from": {
+				N: aws.String(strconv.Itoa(from)),
+			},
+			":to": {
+				N: aws.String(strconv.Itoa(to)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// LastSeen implements Store
+func (d DynamoDB) LastSeen(userIds []string) (map[string]int64, error) {
+	res := map[string]int64{}
+	if len(userIds) == 0 {
+		return res, nil
+	}
+
+	// BatchGetItem rejects duplicate keys
+	keys := []map[string]*dynamodb.AttributeValue{}
+	seen := map[string]bool{}
+	for _, userId := range userIds {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+		})
+	}
+
+	// the keys not processed due to throughput limits are requested again
+	items := map[string]*dynamodb.KeysAndAttributes{
+		d.TableName: {
+			Keys: keys,
+		},
+	}
+	for len(items) > 0 {
+		out, err := d.DynamoDB.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: items,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range out.Responses[d.TableName] {
+			if item["UserId"] == nil || item["LastSeen"] == nil {
+				continue
+			}
+
+			lastSeen, err := strconv.ParseInt(aws.StringValue(item["LastSeen"].N), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			res[aws.StringValue(item["UserId"].S)] = lastSeen
+		}
+
+		items = out.UnprocessedKeys
+	}
+
+	return res, nil
+}
@@ -0,0 +1,136 @@
+package presence
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+// policies applied when the user reaches the maximum of connections
+const (
+	PolicyReject      = "reject"
+	PolicyEvictOldest = "evict-oldest"
+)
+
+// Limit enforces the maximum of concurrent connections per user, it's
+// applied whenever a connection gets its user, either at $connect or
+// when the anonymous connection is authorized
+type Limit struct {
+	Connections connection.ConnectionStore
+	Presence    Store
+	Logger      *zap.Logger
+
+	// SQS and SQSURL of the delete connection queue the evicted
+	// connections are closed through
+	SQS    sqsiface.SQSAPI
+	SQSURL string
+
+	// Max is the maximum of connections per user, 0 means no limit,
+	// Policy decides what happens when it's reached
+	Max    int
+	Policy string
+}
+
+// Admit counts the new connection of the user if the user is below the
+// limit, otherwise it either refuses the connection or evicts the oldest
+// connection of the user depending on the policy, the admitted connection
+// has to be released once it's closed or if it's not opened after all
+func (l Limit) Admit(userId string) (bool, error) {
+	admitted, err := l.Presence.Acquire(userId, l.Max)
+	if err != nil || admitted {
+		return admitted, err
+	}
+
+	// the counter misses the connections which expired without
+	// $disconnect, so it's fixed from the records before giving up,
+	// the counter is only lowered and only if no other connect or
+	// disconnect changed it meanwhile
+	current, err := l.Presence.Connections(userId)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := l.Connections.CountByUserId(userId)
+	if err != nil {
+		return false, err
+	}
+
+	if count < current {
+		reset, err := l.Presence.ResetConnections(userId, current, count)
+		if err != nil {
+			return false, err
+		}
+
+		if reset {
+			l.Logger.Info("connection counter fixed",
+				zap.String("userId", userId),
+				zap.Int("counter", current),
+				zap.Int("records", count),
+			)
+		}
+
+		admitted, err = l.Presence.Acquire(userId, l.Max)
+		if err != nil || admitted {
+			return admitted, err
+		}
+	}
+
+	if l.Policy != PolicyEvictOldest {
+		return false, nil
+	}
+
+	// the oldest connections are closed through the delete connection
+	// queue, they are marked as evicted and uncounted right away so
+	// the concurrent connects can't evict the same connections and
+	// the limit still holds
+	conns, err := l.Connections.GetByUserId(userId)
+	if err != nil {
+		return false, err
+	}
+
+	current, err = l.Presence.Connections(userId)
+	if err != nil {
+		return false, err
+	}
+
+	// evict enough connections to get below the limit
+	evict := current - l.Max + 1
+	for i := len(conns) - 1; i >= 0 && evict > 0; i-- {
+		oldest := conns[i]
+
+		err = l.Connections.Evict(oldest.ConnectionId)
+		if errors.Is(err, connection.ErrNotFound) {
+			// closed or evicted by another connect
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		err = l.Presence.Release(userId)
+		if err != nil {
+			return false, err
+		}
+		evict--
+
+		l.Logger.Info("too many connections, evicting the oldest",
+			zap.String("userId", userId),
+			zap.String("connectionId", oldest.ConnectionId),
+		)
+
+		r := request.DeleteConnectionFromId(oldest.ConnectionId)
+		r.Reason = request.ReasonEvicted
+
+		err = r.DeleteSQS(l.SQS, l.SQSURL)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// the connection is rejected if the concurrent connects
+	// took the room first
+	return l.Presence.Acquire(userId, l.Max)
+}
@@ -0,0 +1,124 @@
+package presence
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pipetail/sst-websocket/internal/awstest"
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+	"github.com/pipetail/sst-websocket/pkg/request"
+	"go.uber.org/zap"
+)
+
+func newTestLimit(t *testing.T, policy string, connectionIds ...string) (Limit, *awstest.SQS) {
+	t.Helper()
+
+	s := awstest.NewSQS()
+	l := Limit{
+		Connections: connection.NewMemory(),
+		Presence:    NewMemory(),
+		Logger:      zap.NewNop(),
+		SQS:         s,
+		SQSURL:      "delete",
+		Max:         2,
+		Policy:      policy,
+	}
+
+	// the connections of the user from the oldest
+	storetest.Open(t, l.Connections, l.Presence, "1234", connectionIds...)
+
+	return l, s
+}
+
+func TestMemoryAcquireRelease(t *testing.T) {
+	m := NewMemory()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := m.Acquire("1234", 2); !ok {
+			t.Fatalf("expected connection %d to be acquired", i)
+		}
+	}
+	if ok, _ := m.Acquire("1234", 2); ok {
+		t.Fatal("expected the limit to be reached")
+	}
+	if ok, _ := m.Acquire("1234", 0); !ok {
+		t.Fatal("expected no limit")
+	}
+
+	for i := 0; i < 4; i++ {
+		_ = m.Release("1234")
+	}
+	if count, _ := m.Connections("1234"); count != 0 {
+		t.Fatalf("expected the counter not to go below 0, got %d", count)
+	}
+
+	// the counter is only reset from the expected value
+	_, _ = m.Acquire("1234", 0)
+	if ok, _ := m.ResetConnections("1234", 2, 0); ok {
+		t.Fatal("expected no reset of the changed counter")
+	}
+	if ok, _ := m.ResetConnections("1234", 1, 0); !ok {
+		t.Fatal("expected reset")
+	}
+}
+
+func TestLimitAdmit(t *testing.T) {
+	l, _ := newTestLimit(t, PolicyReject, "c1")
+
+	if ok, err := l.Admit("1234"); err != nil || !ok {
+		t.Fatalf("expected admission, got %v %v", ok, err)
+	}
+	if err := l.Connections.Create(connection.New("c2", "1234")); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := l.Admit("1234"); err != nil || ok {
+		t.Fatalf("expected rejection, got %v %v", ok, err)
+	}
+}
+
+func TestLimitAdmitStaleCounter(t *testing.T) {
+	l, _ := newTestLimit(t, PolicyReject, "c1", "c2")
+
+	// the connection expired without $disconnect
+	if err := l.Connections.Delete("c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := l.Admit("1234"); err != nil || !ok {
+		t.Fatalf("expected admission, got %v %v", ok, err)
+	}
+	if count, _ := l.Presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+}
+
+func TestLimitAdmitEvictOldest(t *testing.T) {
+	l, s := newTestLimit(t, PolicyEvictOldest, "c1", "c2")
+
+	if ok, err := l.Admit("1234"); err != nil || !ok {
+		t.Fatalf("expected admission, got %v %v", ok, err)
+	}
+
+	messages := s.Messages("delete")
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 eviction, got %d", len(messages))
+	}
+	r, err := request.DeleteConnectionFromString(aws.StringValue(messages[0].MessageBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ConnectionId != "c1" || r.Reason != request.ReasonEvicted {
+		t.Fatalf("expected eviction of c1, got %+v", r)
+	}
+
+	// the evicted connection is released once, its $disconnect
+	// doesn't release it again
+	if err := Forget(l.Connections, l.Presence, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := l.Presence.Connections("1234"); count != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", count)
+	}
+}
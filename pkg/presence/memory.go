@@ -0,0 +1,112 @@
+package presence
+
+import (
+	"sync"
+)
+
+// Memory stores the last seen times in memory, it's safe for concurrent use
+type Memory struct {
+	mu       sync.Mutex
+	lastSeen map[string]int64
+	online   map[string]bool
+	counts   map[string]int
+}
+
+// NewMemory creates an empty in-memory presence store
+func NewMemory() *Memory {
+	return &Memory{
+		lastSeen: map[string]int64{},
+		online:   map[string]bool{},
+		counts:   map[string]int{},
+	}
+}
+
+// Touch implements Store
+func (m *Memory) Touch(userId string, at int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastSeen[userId] = at
+	return nil
+}
+
+// Published implements Store
+func (m *Memory) Published(userId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.online[userId], nil
+}
+
+// Publish implements Store
+func (m *Memory) Publish(userId string, online bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.online[userId] == online {
+		return false, nil
+	}
+
+	m.online[userId] = online
+	return true, nil
+}
+
+// Acquire implements Store
+func (m *Memory) Acquire(userId string, max int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if max > 0 && m.counts[userId] >= max {
+		return false, nil
+	}
+
+	m.counts[userId]++
+	return true, nil
+}
+
+// Release implements Store
+func (m *Memory) Release(userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counts[userId] > 0 {
+		m.counts[userId]--
+	}
+	return nil
+}
+
+// Connections implements Store
+func (m *Memory) Connections(userId string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counts[userId], nil
+}
+
+// ResetConnections implements Store
+func (m *Memory) ResetConnections(userId string, from int, to int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counts[userId] != from {
+		return false, nil
+	}
+
+	m.counts[userId] = to
+	return true, nil
+}
+
+// LastSeen implements Store
+func (m *Memory) LastSeen(userIds []string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := map[string]int64{}
+	for _, userId := range userIds {
+		if at, ok := m.lastSeen[userId]; ok {
+			res[userId] = at
+		}
+	}
+
+	return res, nil
+}
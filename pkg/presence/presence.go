@@ -0,0 +1,120 @@
+package presence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pipetail/sst-websocket/pkg/connection"
+)
+
+// MaxBatch is the maximum number of users looked up at once
+const MaxBatch = 100
+
+// Presence tells whether the user is reachable over the websocket, on how
+// many connections and when the user was last seen, users online are seen
+// right now
+type Presence struct {
+	UserId      string `json:"userId"`
+	Online      bool   `json:"online"`
+	Connections int    `json:"connections"`
+	LastSeen    int64  `json:"lastSeen,omitempty"`
+}
+
+// Store keeps the time when the users were last seen, it's updated when
+// the connections are opened and closed
+type Store interface {
+	Touch(userId string, at int64) error
+
+	// LastSeen returns the last seen times of at most MaxBatch users,
+	// users which were never seen are missing in the result
+	LastSeen(userIds []string) (map[string]int64, error)
+
+	// Published returns the state last announced to the followers,
+	// users never announced are considered offline
+	Published(userId string) (bool, error)
+
+	// Publish records the state announced to the followers once they
+	// were notified, it returns false if the state was already announced
+	// by a concurrent check
+	Publish(userId string, online bool) (bool, error)
+
+	// Acquire counts the new connection of the user if the user has less
+	// than max connections, max 0 means no limit, it returns false if the
+	// limit was reached
+	Acquire(userId string, max int) (bool, error)
+
+	// Release uncounts the closed connection of the user
+	Release(userId string) error
+
+	// Connections returns the current value of the counter
+	Connections(userId string) (int, error)
+
+	// ResetConnections sets the counter to the given count only if it
+	// still holds the value read before, it returns false if the counter
+	// was changed meanwhile, it fixes the counter when the connections
+	// expired without $disconnect
+	ResetConnections(userId string, from int, to int) (bool, error)
+}
+
+// Get returns presence of the users, the number of connections is
+// counted from the connections of the users so it's always up to date
+// even if $disconnect was not delivered
+func Get(connections connection.ConnectionStore, store Store, userIds []string) ([]Presence, error) {
+	if len(userIds) > MaxBatch {
+		return nil, fmt.Errorf("too many users, at most %d can be looked up at once", MaxBatch)
+	}
+
+	lastSeen, err := store.LastSeen(userIds)
+	if err != nil {
+		return nil, fmt.Errorf("could not get last seen: %s", err)
+	}
+
+	now := time.Now().Unix()
+	res := []Presence{}
+	for _, userId := range userIds {
+		count, err := connections.CountByUserId(userId)
+		if err != nil {
+			return nil, fmt.Errorf("could not count connections: %s", err)
+		}
+
+		p := Presence{
+			UserId:      userId,
+			Online:      count > 0,
+			Connections: count,
+			LastSeen:    lastSeen[userId],
+		}
+		if p.Online {
+			p.LastSeen = now
+		}
+
+		res = append(res, p)
+	}
+
+	return res, nil
+}
+
+// Forget deletes the record of the closed connection and uncounts it from
+// the connections of its user, it's used whenever API Gateway reports the
+// connection as gone since $disconnect is not always delivered
+func Forget(connections connection.ConnectionStore, store Store, connectionId string) error {
+	// the expired records were counted as well, the connection removed
+	// meanwhile by $disconnect was uncounted by it
+	c, err := connections.Remove(connectionId)
+	if errors.Is(err, connection.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete connection: %s", err)
+	}
+
+	// the connection no longer counts towards the limit
+	if c.Counted() {
+		err = store.Release(c.UserId)
+		if err != nil {
+			return fmt.Errorf("could not release connection: %s", err)
+		}
+	}
+
+	return nil
+}
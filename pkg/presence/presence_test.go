@@ -0,0 +1,67 @@
+package presence
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pipetail/sst-websocket/internal/storetest"
+	"github.com/pipetail/sst-websocket/pkg/connection"
+)
+
+func TestGet(t *testing.T) {
+	connections := connection.NewMemory()
+	store := NewMemory()
+
+	storetest.Open(t, connections, store, "online", "c1", "c2")
+	_ = store.Touch("offline", 1000)
+
+	// the counter missed $disconnect, the records are counted instead
+	_, _ = store.Acquire("online", 0)
+
+	res, err := Get(connections, store, []string{"online", "offline", "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := res[0]; !p.Online || p.Connections != 2 || p.LastSeen == 0 {
+		t.Fatalf("expected online user with 2 connections, got %+v", p)
+	}
+	if p := res[1]; p.Online || p.Connections != 0 || p.LastSeen != 1000 {
+		t.Fatalf("expected offline user last seen at 1000, got %+v", p)
+	}
+	if p := res[2]; p.Online || p.LastSeen != 0 {
+		t.Fatalf("expected unknown user, got %+v", p)
+	}
+}
+
+func TestGetTooManyUsers(t *testing.T) {
+	userIds := []string{}
+	for i := 0; i <= MaxBatch; i++ {
+		userIds = append(userIds, fmt.Sprintf("user%d", i))
+	}
+
+	if _, err := Get(connection.NewMemory(), NewMemory(), userIds); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestForget(t *testing.T) {
+	connections := connection.NewMemory()
+	store := NewMemory()
+	storetest.Open(t, connections, store, "1234", "c1", "c2")
+	storetest.Open(t, connections, store, "", "anonymous")
+
+	for _, id := range []string{"c1", "c1", "anonymous", "unknown"} {
+		if err := Forget(connections, store, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the connection is uncounted once, the anonymous one was never counted
+	if count, _ := store.Connections("1234"); count != 1 {
+		t.Fatalf("expected 1 counted connection, got %d", count)
+	}
+	if _, err := connections.Find("anonymous"); err != connection.ErrNotFound {
+		t.Fatalf("expected anonymous connection removed, got %v", err)
+	}
+}
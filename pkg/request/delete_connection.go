@@ -3,13 +3,38 @@ package request
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
+// reasons why the connection is deleted
+const (
+	ReasonExpired      = "expired"
+	ReasonRevoked      = "revoked"
+	ReasonUserMismatch = "user_mismatch"
+	ReasonIdle         = "idle"
+	ReasonEvicted      = "evicted"
+
+	// reasons of closing all connections of the user
+	ReasonLogout         = "logout"
+	ReasonSecurity       = "security"
+	ReasonAccountDeleted = "account_deleted"
+)
+
+// MaxDelay is the longest delay supported by SQS, the requests scheduled
+// further in the future are re-enqueued by the consumer
+const MaxDelay = 15 * time.Minute
+
+// DeleteConnection requests closing of the connection, the request with
+// ExpiresAt set is scheduled for the end of the session and it's
+// valid only while the session is not extended
 type DeleteConnection struct {
 	ConnectionId string `json:"connectionId"`
+	Reason       string `json:"reason,omitempty"`
+	ExpiresAt    int64  `json:"expiresAt,omitempty"`
 }
 
 // DeleteConnectionFromString decodes json to DeleteConnection
@@ -26,7 +51,33 @@ func DeleteConnectionFromId(id string) DeleteConnection {
 	}
 }
 
-func (n DeleteConnection) DeleteDelayedSQS(sqsSvc *sqs.SQS, url string) error {
+// DeleteConnectionAt creates request to close the connection when
+// its session expires
+func DeleteConnectionAt(id string, expiresAt int64) DeleteConnection {
+	return DeleteConnection{
+		ConnectionId: id,
+		Reason:       ReasonExpired,
+		ExpiresAt:    expiresAt,
+	}
+}
+
+// Remaining returns time left until the scheduled deletion
+func (n DeleteConnection) Remaining() time.Duration {
+	return time.Until(time.Unix(n.ExpiresAt, 0))
+}
+
+// DeleteDelayedSQS schedules the deletion for ExpiresAt, requests further
+// in the future than MaxDelay are delivered after MaxDelay and have to be
+// re-enqueued
+func (n DeleteConnection) DeleteDelayedSQS(sqsSvc sqsiface.SQSAPI, url string) error {
+	delay := n.Remaining()
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+
 	// serialize ConnectionNotification
 	data, err := json.Marshal(n)
 	if err != nil {
@@ -37,7 +88,24 @@ func (n DeleteConnection) DeleteDelayedSQS(sqsSvc *sqs.SQS, url string) error {
 	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
 		QueueUrl:     aws.String(url),
 		MessageBody:  aws.String(string(data)),
-		DelaySeconds: aws.Int64(900),
+		DelaySeconds: aws.Int64(int64(delay.Seconds())),
+	})
+
+	return err
+}
+
+// DeleteSQS requests immediate deletion of the connection
+func (n DeleteConnection) DeleteSQS(sqsSvc sqsiface.SQSAPI, url string) error {
+	// serialize DeleteConnection
+	data, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("could not encode message body: %s", err)
+	}
+
+	// send message to SQS
+	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(url),
+		MessageBody: aws.String(string(data)),
 	})
 
 	return err
@@ -0,0 +1,51 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// LogoutUser requests closing of all connections of the user, the reason
+// is passed to every connection
+type LogoutUser struct {
+	UserId string `json:"userId"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// LogoutUserFromString decodes json to LogoutUser
+func LogoutUserFromString(request string) (LogoutUser, error) {
+	u := LogoutUser{}
+	err := json.Unmarshal([]byte(request), &u)
+	return u, err
+}
+
+// ValidLogoutReason tells whether the reason can be used to log out the user
+func ValidLogoutReason(reason string) bool {
+	switch reason {
+	case ReasonLogout, ReasonSecurity, ReasonAccountDeleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// LogoutSQS requests closing of all connections of the user
+func (n LogoutUser) LogoutSQS(sqsSvc sqsiface.SQSAPI, url string) error {
+	// serialize LogoutUser
+	data, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("could not encode message body: %s", err)
+	}
+
+	// send message to SQS
+	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(url),
+		MessageBody: aws.String(string(data)),
+	})
+
+	return err
+}
@@ -0,0 +1,47 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// PresenceCheck requests comparing the presence of the user with the
+// state announced to the followers, it's delayed so the connections
+// opened and closed in the meantime don't notify the followers
+type PresenceCheck struct {
+	UserId string `json:"userId"`
+}
+
+// PresenceCheckFromString decodes json to PresenceCheck
+func PresenceCheckFromString(request string) (PresenceCheck, error) {
+	u := PresenceCheck{}
+	err := json.Unmarshal([]byte(request), &u)
+	return u, err
+}
+
+// CheckDelayedSQS schedules the check after the given delay, at most MaxDelay
+func (n PresenceCheck) CheckDelayedSQS(sqsSvc sqsiface.SQSAPI, url string, delay time.Duration) error {
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+
+	// serialize PresenceCheck
+	data, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("could not encode message body: %s", err)
+	}
+
+	// send message to SQS
+	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:     aws.String(url),
+		MessageBody:  aws.String(string(data)),
+		DelaySeconds: aws.Int64(int64(delay.Seconds())),
+	})
+
+	return err
+}
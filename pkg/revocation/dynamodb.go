@@ -0,0 +1,112 @@
+package revocation
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDB stores revoked token ids in the given DynamoDB table, the table
+// should have TTL enabled on the ExpiresAt attribute so the ids are removed
+// once the tokens expire
+type DynamoDB struct {
+	DynamoDB  *dynamodb.DynamoDB
+	TableName string
+}
+
+// NewDynamoDB creates revocation store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// Revoke implements Store
+func (d DynamoDB) Revoke(tokenId string, expiresAt int64) error {
+	_, err := d.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"TokenId": {
+				S: aws.String(tokenId),
+			},
+			"ExpiresAt": {
+				N: aws.String(strconv.FormatInt(expiresAt, 10)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// IsRevoked implements Store
+func (d DynamoDB) IsRevoked(tokenId string) (bool, error) {
+	item, err := d.get(tokenId)
+	if err != nil || item == nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RevokeUser implements Store
+func (d DynamoDB) RevokeUser(userId string, notBefore int64, expiresAt int64) error {
+	_, err := d.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"TokenId": {
+				S: aws.String(userKey(userId)),
+			},
+			"NotBefore": {
+				N: aws.String(strconv.FormatInt(notBefore, 10)),
+			},
+			"ExpiresAt": {
+				N: aws.String(strconv.FormatInt(expiresAt, 10)),
+			},
+		},
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// RevokedBefore implements Store
+func (d DynamoDB) RevokedBefore(userId string) (int64, error) {
+	item, err := d.get(userKey(userId))
+	if err != nil || item == nil || item["NotBefore"] == nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(aws.StringValue(item["NotBefore"].N), 10, 64)
+}
+
+// get returns the revocation item unless it's missing or expired
+func (d DynamoDB) get(key string) (map[string]*dynamodb.AttributeValue, error) {
+	res, err := d.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"TokenId": {
+				S: aws.String(key),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(d.TableName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// DynamoDB TTL deletes items lazily, expired revocations
+	// are irrelevant as the token is expired as well
+	if res.Item == nil || res.Item["ExpiresAt"] == nil {
+		return nil, nil
+	}
+	expiresAt, err := strconv.ParseInt(aws.StringValue(res.Item["ExpiresAt"].N), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt <= time.Now().Unix() {
+		return nil, nil
+	}
+
+	return res.Item, nil
+}
@@ -0,0 +1,61 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory stores revoked token ids in memory, it's safe for concurrent use
+type Memory struct {
+	mu      sync.Mutex
+	revoked map[string]int64
+	users   map[string][2]int64
+}
+
+// NewMemory creates an empty in-memory revocation store
+func NewMemory() *Memory {
+	return &Memory{
+		revoked: map[string]int64{},
+		users:   map[string][2]int64{},
+	}
+}
+
+// Revoke implements Store
+func (m *Memory) Revoke(tokenId string, expiresAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revoked[tokenId] = expiresAt
+	return nil
+}
+
+// IsRevoked implements Store
+func (m *Memory) IsRevoked(tokenId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt, ok := m.revoked[tokenId]
+	return ok && expiresAt > time.Now().Unix(), nil
+}
+
+// RevokeUser implements Store
+func (m *Memory) RevokeUser(userId string, notBefore int64, expiresAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[userId] = [2]int64{notBefore, expiresAt}
+	return nil
+}
+
+// RevokedBefore implements Store
+func (m *Memory) RevokedBefore(userId string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.users[userId]
+	if !ok || r[1] <= time.Now().Unix() {
+		return 0, nil
+	}
+
+	return r[0], nil
+}
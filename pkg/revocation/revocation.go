@@ -0,0 +1,21 @@
+package revocation
+
+// Store keeps ids of revoked tokens until the tokens expire
+type Store interface {
+	Revoke(tokenId string, expiresAt int64) error
+	IsRevoked(tokenId string) (bool, error)
+
+	// RevokeUser revokes all credentials of the user issued before
+	// notBefore, it's needed only until the credentials expire
+	RevokeUser(userId string, notBefore int64, expiresAt int64) error
+
+	// RevokedBefore returns the time before which the credentials of
+	// the user were revoked, 0 means none were
+	RevokedBefore(userId string) (int64, error)
+}
+
+// userKey separates the user revocations from the token ids
+// stored in the same table
+func userKey(userId string) string {
+	return "user#" + userId
+}
@@ -0,0 +1,74 @@
+package ticket
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// DynamoDB stores tickets in the given DynamoDB table, the table
+// should have TTL enabled on the ExpiresAt attribute to clean up
+// the tickets that were never used
+type DynamoDB struct {
+	DynamoDB  *dynamodb.DynamoDB
+	TableName string
+}
+
+// NewDynamoDB creates ticket store backed by the DynamoDB table
+func NewDynamoDB(dynamoDbSvc *dynamodb.DynamoDB, table string) DynamoDB {
+	return DynamoDB{
+		DynamoDB:  dynamoDbSvc,
+		TableName: table,
+	}
+}
+
+// Put implements Store
+func (d DynamoDB) Put(t Ticket) error {
+	av, err := dynamodbattribute.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(d.TableName),
+	})
+	return err
+}
+
+// Consume implements Store, the conditional delete makes sure the ticket
+// is used just once and only before it expires
+func (d DynamoDB) Consume(ticketId string) (Ticket, error) {
+	res, err := d.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"TicketId": {
+				S: aws.String(ticketId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(TicketId) AND ExpiresAt > :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+			},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+		TableName:    aws.String(d.TableName),
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return Ticket{}, ErrNotFound
+	}
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	t := Ticket{}
+	err = dynamodbattribute.UnmarshalMap(res.Attributes, &t)
+	return t, err
+}
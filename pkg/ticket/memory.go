@@ -0,0 +1,47 @@
+package ticket
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory stores tickets in memory, it's safe for concurrent use
+type Memory struct {
+	mu      sync.Mutex
+	tickets map[string]Ticket
+}
+
+// NewMemory creates an empty in-memory ticket store
+func NewMemory() *Memory {
+	return &Memory{
+		tickets: map[string]Ticket{},
+	}
+}
+
+// Put implements Store
+func (m *Memory) Put(t Ticket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tickets[t.TicketId] = t
+	return nil
+}
+
+// Consume implements Store
+func (m *Memory) Consume(ticketId string) (Ticket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tickets[ticketId]
+	if !ok {
+		return Ticket{}, ErrNotFound
+	}
+
+	delete(m.tickets, ticketId)
+
+	if t.ExpiresAt <= time.Now().Unix() {
+		return Ticket{}, ErrNotFound
+	}
+
+	return t, nil
+}
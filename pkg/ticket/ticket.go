@@ -0,0 +1,60 @@
+package ticket
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TTL is how long the ticket can be used to open a connection
+const TTL = 30 * time.Second
+
+var ErrNotFound = errors.New("ticket not found, expired or already used")
+
+// Ticket is an opaque single-use credential bound to the user, it's
+// meant to be put in the query string instead of the token, the id
+// of the token the ticket was exchanged for is kept so the connection
+// can be closed when the token is revoked, scopes and expiration of
+// the token are passed to the connection
+type Ticket struct {
+	TicketId         string
+	UserId           string
+	TokenId          string
+	Scopes           []string `dynamodbav:",omitempty"`
+	SessionExpiresAt int64
+	ExpiresAt        int64
+
+	// AuthTime is when the user authenticated to get the token, it's
+	// checked against the revocations of the user
+	AuthTime int64 `dynamodbav:",omitempty"`
+}
+
+// New creates a random ticket for the given user and token, the session
+// opened with the ticket expires together with the token
+func New(userId string, tokenId string, scopes []string, sessionExpiresAt int64) (Ticket, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return Ticket{}, fmt.Errorf("could not generate ticket id: %s", err)
+	}
+
+	return Ticket{
+		TicketId:         base64.RawURLEncoding.EncodeToString(b),
+		UserId:           userId,
+		TokenId:          tokenId,
+		Scopes:           scopes,
+		SessionExpiresAt: sessionExpiresAt,
+		ExpiresAt:        time.Now().Add(TTL).Unix(),
+	}, nil
+}
+
+// Store persists tickets until they are consumed
+type Store interface {
+	Put(t Ticket) error
+
+	// Consume removes the ticket and returns it, only one of concurrent
+	// callers succeeds, the others get ErrNotFound
+	Consume(ticketId string) (Ticket, error)
+}
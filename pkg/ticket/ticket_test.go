@@ -0,0 +1,82 @@
+package ticket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryConsume(t *testing.T) {
+	m := NewMemory()
+
+	tk, err := New("1234", "fam", []string{"ping"}, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	consumed, err := m.Consume(tk.TicketId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed.UserId != "1234" || consumed.TokenId != "fam" {
+		t.Fatalf("unexpected ticket %+v", consumed)
+	}
+
+	// the ticket is single-use
+	if _, err := m.Consume(tk.TicketId); err != ErrNotFound {
+		t.Fatalf("expected error %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemoryConsumeExpired(t *testing.T) {
+	m := NewMemory()
+
+	tk, err := New("1234", "fam", nil, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	if err := m.Put(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Consume(tk.TicketId); err != ErrNotFound {
+		t.Fatalf("expected error %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemoryConsumeConcurrent(t *testing.T) {
+	m := NewMemory()
+
+	tk, err := New("1234", "fam", nil, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put(tk); err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of the connections opened with the same ticket succeeds
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	consumed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.Consume(tk.TicketId); err == nil {
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if consumed != 1 {
+		t.Fatalf("expected the ticket consumed once, got %d", consumed)
+	}
+}
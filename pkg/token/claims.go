@@ -0,0 +1,197 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Claims is the payload carried by the connect token
+type Claims struct {
+	UserId    string `json:"userId"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Id        string `json:"jti"`
+
+	// FamilyId is shared by the token and all the tokens refreshed from it,
+	// revoking the family revokes all of them
+	FamilyId string `json:"fam,omitempty"`
+
+	// AuthTime is the time the user authenticated, the refreshed tokens
+	// keep it so the family can't be refreshed forever
+	AuthTime int64 `json:"auth_time,omitempty"`
+
+	// Scope is a space separated list of the granted scopes
+	Scope string `json:"scope,omitempty"`
+}
+
+// NewClaims creates claims for the given user and scopes valid for the given
+// duration with a random unique id
+func NewClaims(userId string, scopes []string, audience string, ttl time.Duration) (Claims, error) {
+	id, err := newId()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	now := time.Now()
+
+	return Claims{
+		UserId:    userId,
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Id:        id,
+		FamilyId:  id,
+		AuthTime:  now.Unix(),
+		Scope:     JoinScopes(scopes),
+	}, nil
+}
+
+// Refreshed creates claims of a new token of the same family, the token
+// is valid for the given duration but not after the family reaches
+// the maximal lifetime
+func (c Claims) Refreshed(ttl time.Duration, maxLifetime time.Duration) (Claims, error) {
+	now := time.Now()
+
+	limit := c.FamilyExpiresAt(maxLifetime)
+	if now.Unix() >= limit {
+		return Claims{}, ErrLifetimeExceeded
+	}
+
+	id, err := newId()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	expiresAt := now.Add(ttl).Unix()
+	if expiresAt > limit {
+		expiresAt = limit
+	}
+
+	return Claims{
+		UserId:    c.UserId,
+		Audience:  c.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt,
+		Id:        id,
+		FamilyId:  c.Family(),
+		AuthTime:  c.AuthenticatedAt(),
+		Scope:     c.Scope,
+	}, nil
+}
+
+// Family returns the family id, the tokens issued before the families
+// were introduced form a family of their own
+func (c Claims) Family() string {
+	if c.FamilyId == "" {
+		return c.Id
+	}
+
+	return c.FamilyId
+}
+
+// AuthenticatedAt returns the time the user authenticated in epoch seconds
+func (c Claims) AuthenticatedAt() int64 {
+	if c.AuthTime == 0 {
+		return c.IssuedAt
+	}
+
+	return c.AuthTime
+}
+
+// FamilyExpiresAt returns the time after which no token of the family
+// is valid in epoch seconds
+func (c Claims) FamilyExpiresAt(maxLifetime time.Duration) int64 {
+	return c.AuthenticatedAt() + int64(maxLifetime/time.Second)
+}
+
+// Valid checks the claims against the expected audience and the given time
+func (c Claims) Valid(audience string, now time.Time) error {
+	if c.UserId == "" || c.Id == "" {
+		return ErrInvalidClaims
+	}
+
+	if c.Audience != audience {
+		return ErrInvalidAudience
+	}
+
+	if now.Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+
+	// allow some clock skew between the issuer and the verifier
+	if c.IssuedAt > now.Add(time.Minute).Unix() {
+		return ErrInvalidClaims
+	}
+
+	return nil
+}
+
+// Map returns the claims as a map of strings, it's meant to be stored
+// along with the entities authorized by the token
+func (c Claims) Map() map[string]string {
+	return map[string]string{
+		"userId":    c.UserId,
+		"aud":       c.Audience,
+		"iat":       strconv.FormatInt(c.IssuedAt, 10),
+		"exp":       strconv.FormatInt(c.ExpiresAt, 10),
+		"jti":       c.Id,
+		"fam":       c.Family(),
+		"auth_time": strconv.FormatInt(c.AuthenticatedAt(), 10),
+		"scope":     c.Scope,
+	}
+}
+
+// Scopes returns the granted scopes
+func (c Claims) Scopes() []string {
+	return SplitScopes(c.Scope)
+}
+
+// Expires returns the expiration time of the claims
+func (c Claims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// newId generates random hex encoded identifier
+func newId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate token id: %s", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// JoinScopes encodes the scopes to the space separated list
+func JoinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// SplitScopes decodes the space separated list of scopes
+func SplitScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// HasScopes tells whether all the required scopes were granted
+func HasScopes(granted []string, required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, g := range granted {
+			if g == r {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
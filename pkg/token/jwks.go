@@ -0,0 +1,120 @@
+package token
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKS is a set of public keys in the JSON Web Key Set format
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public parts of the asymmetric keys in the set, the symmetric
+// keys are never published
+func (s KeySet) JWKS() JWKS {
+	jwks := JWKS{
+		Keys: []JWK{},
+	}
+
+	for _, k := range s.Keys {
+		if k.public == nil {
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			KeyId:     k.Id,
+			N:         encode(k.public.N.Bytes()),
+			E:         encode(big.NewInt(int64(k.public.E)).Bytes()),
+		})
+	}
+
+	return jwks
+}
+
+// KeySet creates verification only key set from the published keys
+func (j JWKS) KeySet() (KeySet, error) {
+	set := KeySet{}
+
+	for _, jwk := range j.Keys {
+		if jwk.KeyType != "RSA" || jwk.Algorithm != RS256 {
+			continue
+		}
+
+		n, err := decode(jwk.N)
+		if err != nil {
+			return KeySet{}, fmt.Errorf("could not decode modulus of key %s: %s", jwk.KeyId, err)
+		}
+
+		e, err := decode(jwk.E)
+		if err != nil {
+			return KeySet{}, fmt.Errorf("could not decode exponent of key %s: %s", jwk.KeyId, err)
+		}
+
+		set.Keys = append(set.Keys, Key{
+			Id:        jwk.KeyId,
+			Algorithm: RS256,
+			public: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			},
+		})
+	}
+
+	return set, nil
+}
+
+// JWKSSource loads keys published on the JWKS endpoint
+type JWKSSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewJWKSSource creates JWKS key source cached for the given time, it's
+// meant for backends verifying tokens without the access to the key store
+func NewJWKSSource(url string, ttl time.Duration) *CachedKeySource {
+	return NewCachedKeySource(JWKSSource{
+		URL: url,
+		Client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}, ttl)
+}
+
+// Keys implements KeySource
+func (s JWKSSource) Keys() (KeySet, error) {
+	res, err := s.Client.Get(s.URL)
+	if err != nil {
+		return KeySet{}, fmt.Errorf("could not fetch JWKS: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return KeySet{}, fmt.Errorf("could not fetch JWKS: unexpected status %d", res.StatusCode)
+	}
+
+	jwks := JWKS{}
+	err = json.NewDecoder(res.Body).Decode(&jwks)
+	if err != nil {
+		return KeySet{}, fmt.Errorf("could not decode JWKS: %s", err)
+	}
+
+	return jwks.KeySet()
+}
@@ -0,0 +1,121 @@
+package token
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+// Key holds the material used to sign and verify tokens
+type Key struct {
+	Id        string
+	Algorithm string
+	secret    []byte
+	private   *rsa.PrivateKey
+	public    *rsa.PublicKey
+}
+
+// NewHS256Key creates a symmetric key from the given shared secret
+func NewHS256Key(secret []byte) (Key, error) {
+	if len(secret) < 32 {
+		return Key{}, errors.New("HS256 secret must be at least 32 bytes long")
+	}
+
+	return Key{
+		Algorithm: HS256,
+		secret:    secret,
+	}, nil
+}
+
+// NewRS256Key creates an asymmetric key from PEM encoded RSA private key,
+// both PKCS#1 and PKCS#8 encodings are accepted
+func NewRS256Key(privateKeyPEM []byte) (Key, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return Key{}, errors.New("could not decode PEM block")
+	}
+
+	private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return Key{}, fmt.Errorf("could not parse RSA private key: %s", err)
+		}
+
+		var ok bool
+		private, ok = parsed.(*rsa.PrivateKey)
+		if !ok {
+			return Key{}, errors.New("PKCS#8 key is not an RSA key")
+		}
+	}
+
+	return Key{
+		Algorithm: RS256,
+		private:   private,
+		public:    &private.PublicKey,
+	}, nil
+}
+
+// KeyFromString creates key of the given algorithm from its string form,
+// the shared secret for HS256 and PEM encoded private key for RS256
+func KeyFromString(algorithm string, material string) (Key, error) {
+	switch algorithm {
+	case HS256:
+		return NewHS256Key([]byte(material))
+	case RS256:
+		return NewRS256Key([]byte(material))
+	default:
+		return Key{}, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+}
+
+// sign computes signature of the signing input
+func (k Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		if k.private == nil {
+			return nil, errors.New("key can't be used for signing")
+		}
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k.private, crypto.SHA256, digest[:])
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", k.Algorithm)
+	}
+}
+
+// verify checks the signature of the signing input
+func (k Key) verify(input []byte, signature []byte) error {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case RS256:
+		digest := sha256.Sum256(input)
+		if rsa.VerifyPKCS1v15(k.public, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm: %s", k.Algorithm)
+	}
+}
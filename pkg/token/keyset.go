@@ -0,0 +1,123 @@
+package token
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNoSigningKey = errors.New("no signing key available")
+
+// KeySet is a set of keys valid at the same time, at most one of them
+// is used to sign new tokens
+type KeySet struct {
+	Keys         []Key
+	SigningKeyId string
+}
+
+// Lookup returns the key with the given id
+func (s KeySet) Lookup(id string) (Key, bool) {
+	for _, k := range s.Keys {
+		if k.Id == id {
+			return k, true
+		}
+	}
+
+	return Key{}, false
+}
+
+// Signing returns the key used to sign new tokens
+func (s KeySet) Signing() (Key, error) {
+	if s.SigningKeyId == "" {
+		return Key{}, ErrNoSigningKey
+	}
+
+	k, ok := s.Lookup(s.SigningKeyId)
+	if !ok {
+		return Key{}, ErrNoSigningKey
+	}
+
+	return k, nil
+}
+
+// KeySource provides the current key set
+type KeySource interface {
+	Keys() (KeySet, error)
+}
+
+// StaticKeySource always provides the same key set
+type StaticKeySource struct {
+	Set KeySet
+}
+
+// Keys implements KeySource
+func (s StaticKeySource) Keys() (KeySet, error) {
+	return s.Set, nil
+}
+
+// KeyRefresher is implemented by the key sources which can reload the keys
+// before their cache expires, e.g. when a token signed by an unknown key
+// arrives right after the rotation
+type KeyRefresher interface {
+	Refresh() (KeySet, error)
+}
+
+// RefreshInterval limits how often the cached keys are reloaded ahead
+// of time so the tokens with made up key ids can't flood the source
+const RefreshInterval = 30 * time.Second
+
+// CachedKeySource keeps the key set loaded from the underlying source
+// for the given time, it's safe for concurrent use
+type CachedKeySource struct {
+	source KeySource
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	keys   KeySet
+	loaded time.Time
+}
+
+// NewCachedKeySource creates a cache in front of the given source
+func NewCachedKeySource(source KeySource, ttl time.Duration) *CachedKeySource {
+	return &CachedKeySource{
+		source: source,
+		ttl:    ttl,
+	}
+}
+
+// Keys implements KeySource
+func (c *CachedKeySource) Keys() (KeySet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded.IsZero() && time.Since(c.loaded) < c.ttl {
+		return c.keys, nil
+	}
+
+	return c.load()
+}
+
+// Refresh implements KeyRefresher, the keys are reloaded at most once
+// per RefreshInterval
+func (c *CachedKeySource) Refresh() (KeySet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded.IsZero() && time.Since(c.loaded) < RefreshInterval {
+		return c.keys, nil
+	}
+
+	return c.load()
+}
+
+func (c *CachedKeySource) load() (KeySet, error) {
+	keys, err := c.source.Keys()
+	if err != nil {
+		return KeySet{}, err
+	}
+
+	c.keys = keys
+	c.loaded = time.Now()
+
+	return keys, nil
+}
@@ -0,0 +1,288 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrInvalidClaims    = errors.New("invalid token claims")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrExpired          = errors.New("token expired")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrRevoked          = errors.New("token revoked")
+	ErrLifetimeExceeded = errors.New("token lifetime exceeded")
+)
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid,omitempty"`
+	Type      string `json:"typ"`
+}
+
+// Sign encodes the claims to a compact JWS signed by the given key
+func Sign(claims Claims, key Key) (string, error) {
+	h, err := json.Marshal(header{
+		Algorithm: key.Algorithm,
+		KeyId:     key.Id,
+		Type:      "JWT",
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not encode token header: %s", err)
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("could not encode token claims: %s", err)
+	}
+
+	input := encode(h) + "." + encode(c)
+
+	signature, err := key.sign([]byte(input))
+	if err != nil {
+		return "", fmt.Errorf("could not sign token: %s", err)
+	}
+
+	return input + "." + encode(signature), nil
+}
+
+// Parse verifies signature of the token against the key selected by the kid
+// header and returns its claims, the claims itself are not validated
+func Parse(token string, keys KeySet) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	h := header{}
+	err := decodeJSON(parts[0], &h)
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	// tokens without kid are checked against all keys of the same algorithm
+	candidates := keys.Keys
+	if h.KeyId != "" {
+		key, ok := keys.Lookup(h.KeyId)
+		if !ok {
+			return Claims{}, ErrUnknownKey
+		}
+		candidates = []Key{key}
+	}
+
+	err = ErrUnknownKey
+	for _, key := range candidates {
+		// the algorithm has to match the key to avoid algorithm confusion
+		if key.Algorithm != h.Algorithm {
+			continue
+		}
+
+		err = key.verify([]byte(parts[0]+"."+parts[1]), signature)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims := Claims{}
+	err = decodeJSON(parts[1], &claims)
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	return claims, nil
+}
+
+// Issuer creates signed tokens for authenticated users
+type Issuer struct {
+	Keys     KeySource
+	Audience string
+	TTL      time.Duration
+
+	// MaxLifetime limits how long the tokens can be refreshed since
+	// the user authenticated
+	MaxLifetime time.Duration
+}
+
+// Issue creates a new token for the given user and scopes signed by the current
+// signing key
+func (i Issuer) Issue(userId string, scopes []string) (string, Claims, error) {
+	claims, err := NewClaims(userId, scopes, i.Audience, i.TTL)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	return i.sign(claims)
+}
+
+// Refresh creates a new token of the same family as the given claims,
+// ErrLifetimeExceeded is returned once the family can't be refreshed anymore
+func (i Issuer) Refresh(claims Claims) (string, Claims, error) {
+	refreshed, err := claims.Refreshed(i.TTL, i.MaxLifetime)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	return i.sign(refreshed)
+}
+
+// sign signs the claims by the current signing key
+func (i Issuer) sign(claims Claims) (string, Claims, error) {
+	keys, err := i.Keys.Keys()
+	if err != nil {
+		return "", Claims{}, fmt.Errorf("could not load keys: %s", err)
+	}
+
+	key, err := keys.Signing()
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	token, err := Sign(claims, key)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	return token, claims, nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decode(data string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(data)
+}
+
+func decodeJSON(data string, v interface{}) error {
+	b, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// RevocationChecker tells whether the token with the given id was revoked
+// and before which time all credentials of the user were revoked
+type RevocationChecker interface {
+	IsRevoked(tokenId string) (bool, error)
+	RevokedBefore(userId string) (int64, error)
+}
+
+// Validator verifies tokens issued by Issuer, revocations are checked
+// only if the checker is provided
+type Validator struct {
+	Keys        KeySource
+	Audience    string
+	Revocations RevocationChecker
+}
+
+// Validate verifies the token signature and its claims and returns the claims
+func (v Validator) Validate(token string) (Claims, error) {
+	keys, err := v.Keys.Keys()
+	if err != nil {
+		return Claims{}, fmt.Errorf("could not load keys: %s", err)
+	}
+
+	claims, err := Parse(token, keys)
+	if err == ErrUnknownKey {
+		// the token may be signed by a key promoted after the keys
+		// were cached
+		claims, err = v.reparse(token)
+	}
+	if err != nil {
+		return Claims{}, err
+	}
+
+	err = claims.Valid(v.Audience, time.Now())
+	if err != nil {
+		return Claims{}, err
+	}
+
+	// the token is revoked either alone or with its whole family
+	err = v.CheckRevoked(claims.Id)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if claims.Family() != claims.Id {
+		err = v.CheckRevoked(claims.Family())
+		if err != nil {
+			return Claims{}, err
+		}
+	}
+
+	err = v.CheckUserRevoked(claims.UserId, claims.AuthenticatedAt())
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return claims, nil
+}
+
+// reparse verifies the token against freshly loaded keys if the source
+// supports it
+func (v Validator) reparse(token string) (Claims, error) {
+	refresher, ok := v.Keys.(KeyRefresher)
+	if !ok {
+		return Claims{}, ErrUnknownKey
+	}
+
+	keys, err := refresher.Refresh()
+	if err != nil {
+		return Claims{}, fmt.Errorf("could not refresh keys: %s", err)
+	}
+
+	return Parse(token, keys)
+}
+
+// CheckRevoked returns ErrRevoked if the token with the given id was revoked
+func (v Validator) CheckRevoked(tokenId string) error {
+	if v.Revocations == nil {
+		return nil
+	}
+
+	revoked, err := v.Revocations.IsRevoked(tokenId)
+	if err != nil {
+		return fmt.Errorf("could not check token revocation: %s", err)
+	}
+
+	if revoked {
+		return ErrRevoked
+	}
+
+	return nil
+}
+
+// CheckUserRevoked returns ErrRevoked if all credentials of the user
+// authenticated at the given time were revoked
+func (v Validator) CheckUserRevoked(userId string, authTime int64) error {
+	if v.Revocations == nil {
+		return nil
+	}
+
+	notBefore, err := v.Revocations.RevokedBefore(userId)
+	if err != nil {
+		return fmt.Errorf("could not check user revocation: %s", err)
+	}
+
+	if authTime < notBefore {
+		return ErrRevoked
+	}
+
+	return nil
+}
@@ -0,0 +1,511 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pipetail/sst-websocket/pkg/revocation"
+)
+
+const audience = "wsapi"
+
+func testKeys(t *testing.T) (Key, Key) {
+	t.Helper()
+
+	hs, err := NewHS256Key([]byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Id = "hs"
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := NewRS256Key(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(private),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Id = "rs"
+
+	return hs, rs
+}
+
+// forge signs the claims with the given header and key material
+// the way an attacker would
+func forge(t *testing.T, h header, claims Claims, sign func(input []byte) []byte) string {
+	t.Helper()
+
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := encode(hb) + "." + encode(cb)
+	return input + "." + encode(sign([]byte(input)))
+}
+
+func TestParse(t *testing.T) {
+	hs, rs := testKeys(t)
+	keys := KeySet{Keys: []Key{hs, rs}}
+
+	claims, err := NewClaims("1234", []string{"ping"}, audience, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(k Key) string {
+		token, err := Sign(claims, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	// the public key is known to everyone, so it must not work
+	// as the HMAC secret
+	publicPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(rs.public),
+	})
+	publicAsSecret := Key{Algorithm: HS256, secret: publicPEM}
+
+	tampered := claims
+	tampered.UserId = "admin"
+	tamperedPayload, _ := json.Marshal(tampered)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{
+			name:  "HS256",
+			token: sign(hs),
+		},
+		{
+			name:  "RS256",
+			token: sign(rs),
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				parts := strings.Split(sign(rs), ".")
+				return parts[0] + "." + encode(tamperedPayload) + "." + parts[2]
+			}(),
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "tampered signature",
+			token: func() string {
+				parts := strings.Split(sign(hs), ".")
+				signature, _ := decode(parts[2])
+				signature[0] ^= 1
+				return parts[0] + "." + parts[1] + "." + encode(signature)
+			}(),
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "signature of another key",
+			token: func() string {
+				parts := strings.Split(sign(hs), ".")
+				other := strings.Split(sign(rs), ".")
+				return parts[0] + "." + parts[1] + "." + other[2]
+			}(),
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "HS256 header with RS256 kid",
+			token: forge(t, header{Algorithm: HS256, KeyId: "rs", Type: "JWT"}, claims, func(input []byte) []byte {
+				s, _ := publicAsSecret.sign(input)
+				return s
+			}),
+			err: ErrUnknownKey,
+		},
+		{
+			name: "HS256 header without kid signed by public key",
+			token: forge(t, header{Algorithm: HS256, Type: "JWT"}, claims, func(input []byte) []byte {
+				s, _ := publicAsSecret.sign(input)
+				return s
+			}),
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "none with kid",
+			token: forge(t, header{Algorithm: "none", KeyId: "hs", Type: "JWT"}, claims, func([]byte) []byte {
+				return nil
+			}),
+			err: ErrUnknownKey,
+		},
+		{
+			name: "none without kid",
+			token: forge(t, header{Algorithm: "none", Type: "JWT"}, claims, func([]byte) []byte {
+				return nil
+			}),
+			err: ErrUnknownKey,
+		},
+		{
+			name: "unknown kid",
+			token: forge(t, header{Algorithm: HS256, KeyId: "unknown", Type: "JWT"}, claims, func(input []byte) []byte {
+				s, _ := hs.sign(input)
+				return s
+			}),
+			err: ErrUnknownKey,
+		},
+		{
+			name:  "malformed",
+			token: "not.a-token",
+			err:   ErrMalformed,
+		},
+		{
+			name:  "missing algorithm",
+			token: "e30." + strings.Join(strings.Split(sign(hs), ".")[1:], "."),
+			err:   ErrUnknownKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(tt.token, keys)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err == nil && parsed != claims {
+				t.Fatalf("expected claims %+v, got %+v", claims, parsed)
+			}
+		})
+	}
+}
+
+func TestClaimsValid(t *testing.T) {
+	now := time.Now()
+	valid := Claims{
+		UserId:    "1234",
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Id:        "jti",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+		err    error
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Claims) {},
+		},
+		{
+			name:   "expired",
+			modify: func(c *Claims) { c.ExpiresAt = now.Unix() },
+			err:    ErrExpired,
+		},
+		{
+			name:   "issued within the clock skew",
+			modify: func(c *Claims) { c.IssuedAt = now.Add(30 * time.Second).Unix() },
+		},
+		{
+			name:   "not yet valid",
+			modify: func(c *Claims) { c.IssuedAt = now.Add(2 * time.Minute).Unix() },
+			err:    ErrInvalidClaims,
+		},
+		{
+			name:   "wrong audience",
+			modify: func(c *Claims) { c.Audience = "other" },
+			err:    ErrInvalidAudience,
+		},
+		{
+			name:   "missing user",
+			modify: func(c *Claims) { c.UserId = "" },
+			err:    ErrInvalidClaims,
+		},
+		{
+			name:   "missing id",
+			modify: func(c *Claims) { c.Id = "" },
+			err:    ErrInvalidClaims,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+
+			err := c.Valid(audience, now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestValidator(t *testing.T) {
+	hs, rs := testKeys(t)
+	rs.private = nil
+	verifying := StaticKeySource{Set: KeySet{Keys: []Key{hs, rs}}}
+
+	issuer := Issuer{
+		Keys:        StaticKeySource{Set: KeySet{Keys: []Key{hs}, SigningKeyId: "hs"}},
+		Audience:    audience,
+		TTL:         time.Minute,
+		MaxLifetime: time.Hour,
+	}
+
+	issue := func(t *testing.T) (string, Claims) {
+		token, claims, err := issuer.Issue("1234", []string{"ping"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token, claims
+	}
+
+	tests := []struct {
+		name     string
+		prepare  func(t *testing.T, revocations *revocation.Memory) string
+		audience string
+		err      error
+	}{
+		{
+			name: "valid",
+			prepare: func(t *testing.T, _ *revocation.Memory) string {
+				token, _ := issue(t)
+				return token
+			},
+		},
+		{
+			name: "wrong audience",
+			prepare: func(t *testing.T, _ *revocation.Memory) string {
+				token, _ := issue(t)
+				return token
+			},
+			audience: "other",
+			err:      ErrInvalidAudience,
+		},
+		{
+			name: "expired",
+			prepare: func(t *testing.T, _ *revocation.Memory) string {
+				claims, _ := NewClaims("1234", nil, audience, -time.Second)
+				token, _ := Sign(claims, hs)
+				return token
+			},
+			err: ErrExpired,
+		},
+		{
+			name: "revoked token",
+			prepare: func(t *testing.T, revocations *revocation.Memory) string {
+				token, claims := issue(t)
+				_ = revocations.Revoke(claims.Id, claims.ExpiresAt)
+				return token
+			},
+			err: ErrRevoked,
+		},
+		{
+			name: "revoked family",
+			prepare: func(t *testing.T, revocations *revocation.Memory) string {
+				_, claims := issue(t)
+				refreshed, _, err := issuer.Refresh(claims)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = revocations.Revoke(claims.Family(), claims.FamilyExpiresAt(time.Hour))
+				return refreshed
+			},
+			err: ErrRevoked,
+		},
+		{
+			name: "revoked user",
+			prepare: func(t *testing.T, revocations *revocation.Memory) string {
+				token, claims := issue(t)
+				_ = revocations.RevokeUser(claims.UserId, claims.AuthTime+1, time.Now().Add(time.Hour).Unix())
+				return token
+			},
+			err: ErrRevoked,
+		},
+		{
+			name: "authenticated after the user was revoked",
+			prepare: func(t *testing.T, revocations *revocation.Memory) string {
+				token, claims := issue(t)
+				_ = revocations.RevokeUser(claims.UserId, claims.AuthTime, time.Now().Add(time.Hour).Unix())
+				return token
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revocations := revocation.NewMemory()
+			token := tt.prepare(t, revocations)
+
+			aud := tt.audience
+			if aud == "" {
+				aud = audience
+			}
+
+			v := Validator{
+				Keys:        verifying,
+				Audience:    aud,
+				Revocations: revocations,
+			}
+
+			_, err := v.Validate(token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	hs, _ := testKeys(t)
+	issuer := Issuer{
+		Keys:        StaticKeySource{Set: KeySet{Keys: []Key{hs}, SigningKeyId: "hs"}},
+		Audience:    audience,
+		TTL:         time.Hour,
+		MaxLifetime: 2 * time.Hour,
+	}
+
+	_, claims, err := issuer.Issue("1234", []string{"ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the family and the authentication time are kept
+	_, refreshed, err := issuer.Refresh(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Id == claims.Id || refreshed.Family() != claims.Id || refreshed.AuthTime != claims.AuthTime {
+		t.Fatalf("unexpected refreshed claims %+v of %+v", refreshed, claims)
+	}
+	if refreshed.Scope != claims.Scope || refreshed.UserId != claims.UserId {
+		t.Fatalf("unexpected refreshed claims %+v of %+v", refreshed, claims)
+	}
+
+	// the expiration is capped by the lifetime of the family
+	old := refreshed
+	old.AuthTime = time.Now().Add(-90 * time.Minute).Unix()
+	_, capped, err := issuer.Refresh(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capped.ExpiresAt != old.FamilyExpiresAt(issuer.MaxLifetime) {
+		t.Fatalf("expected expiration %d, got %d", old.FamilyExpiresAt(issuer.MaxLifetime), capped.ExpiresAt)
+	}
+
+	// the family can't be refreshed once the lifetime is exceeded
+	old.AuthTime = time.Now().Add(-2 * time.Hour).Unix()
+	_, _, err = issuer.Refresh(old)
+	if !errors.Is(err, ErrLifetimeExceeded) {
+		t.Fatalf("expected error %v, got %v", ErrLifetimeExceeded, err)
+	}
+
+	// tokens issued before the families have a family of their own
+	legacy := Claims{
+		UserId:    "1234",
+		Audience:  audience,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		Id:        "legacy",
+	}
+	_, refreshed, err = issuer.Refresh(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Family() != "legacy" || refreshed.AuthTime != legacy.IssuedAt {
+		t.Fatalf("unexpected refreshed claims %+v of %+v", refreshed, legacy)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	hs, _ := testKeys(t)
+	issuer := Issuer{
+		Keys:     StaticKeySource{Set: KeySet{Keys: []Key{hs}}},
+		Audience: audience,
+		TTL:      time.Minute,
+	}
+
+	_, _, err := issuer.Issue("1234", nil)
+	if !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("expected error %v, got %v", ErrNoSigningKey, err)
+	}
+}
+
+func TestJWKSSource(t *testing.T) {
+	hs, current := testKeys(t)
+	_, next := testKeys(t)
+	next.Id = "next"
+
+	// the endpoint publishes the next key only after the rotation
+	published := KeySet{Keys: []Key{hs, current}}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		_ = json.NewEncoder(w).Encode(published.JWKS())
+	}))
+	defer server.Close()
+
+	source := NewJWKSSource(server.URL, time.Hour)
+	validator := Validator{
+		Keys:     source,
+		Audience: audience,
+	}
+
+	issue := func(t *testing.T, key Key) string {
+		t.Helper()
+
+		token, _, err := Issuer{
+			Keys:     StaticKeySource{Set: KeySet{Keys: []Key{key}, SigningKeyId: key.Id}},
+			Audience: audience,
+			TTL:      time.Minute,
+		}.Issue("1234", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	_, err := validator.Validate(issue(t, current))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the symmetric keys are never published
+	_, err = validator.Validate(issue(t, hs))
+	if err != ErrUnknownKey {
+		t.Fatalf("expected error %v, got %v", ErrUnknownKey, err)
+	}
+
+	// the unknown key doesn't reload the keys again right after they were loaded
+	published = KeySet{Keys: []Key{current, next}}
+	_, err = validator.Validate(issue(t, next))
+	if err != ErrUnknownKey || requests != 1 {
+		t.Fatalf("expected error %v after 1 request, got %v after %d", ErrUnknownKey, err, requests)
+	}
+
+	// the key promoted since the last load is picked up without waiting for the cache
+	source.loaded = time.Now().Add(-RefreshInterval)
+	_, err = validator.Validate(issue(t, next))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+}
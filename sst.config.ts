@@ -1,5 +1,5 @@
 import { SSTConfig } from "sst";
-import { Api, WebSocketApi, Table, Queue } from "sst/constructs";
+import { Api, Cron, Function, WebSocketApi, Table, Queue } from "sst/constructs";
 import * as iam from "aws-cdk-lib/aws-iam";
 import * as sqs from "aws-cdk-lib/aws-sqs";
 
@@ -18,8 +18,20 @@ export default {
     app.stack(function Stack({ stack }) {
 
       // queues
-      const notifyConnection = new Queue(stack, "notifyConnection");
+      const notifyConnectionDeadLetter = new Queue(stack, "notifyConnectionDeadLetter");
+      const notifyConnection = new Queue(stack, "notifyConnection", {
+        cdk: {
+          queue: {
+            deadLetterQueue: {
+              queue: notifyConnectionDeadLetter.cdk.queue,
+              maxReceiveCount: 4,
+            },
+          }
+        }
+      });
       const notifyUser = new Queue(stack, "notifyUser");
+      const presenceCheck = new Queue(stack, "presenceCheck");
+      const logoutUser = new Queue(stack, "logoutUser");
       const deleteConnectionDeadLetter = new Queue(stack, "deleteConnectionDeadLetter");
       const deleteConnection = new Queue(stack, "deleteConnection", {
         cdk: {
@@ -32,31 +44,328 @@ export default {
         }
       });
       
-      // REST api
-      const api = new Api(stack, "api", {
-        routes: {
-          "POST /token": "cmd/token/issuer/main.go",
+      // token signing keys, managed by cmd/token/keys
+      const keys = new Table(stack, "keys", {
+        fields: {
+          Id: "string",
         },
+        primaryIndex: { partitionKey: "Id" },
       });
 
-      // persistence for websockets
-      const userIdIndexName = 'UserIdIndex';
+      // ids of revoked tokens kept until the tokens expire
+      const revocations = new Table(stack, "revocations", {
+        fields: {
+          TokenId: "string",
+        },
+        primaryIndex: { partitionKey: "TokenId" },
+        timeToLiveAttribute: "ExpiresAt",
+      });
+
+      // token configuration shared by issuer and verifiers, only the issuer
+      // reads the keys table, the verifiers load the published public keys
+      const tokenEnvironment = {
+        CONFIG_REVOCATIONS_TABLE_ID: revocations.tableName,
+        CONFIG_TOKEN_AUDIENCE: "wsapi",
+        CONFIG_TOKEN_TTL: "900",
+        // the token can be refreshed for a day since the user
+        // authenticated, then the user has to authenticate again
+        CONFIG_TOKEN_MAX_LIFETIME: "86400",
+      };
+
+      // persistence for websockets, the records of the connections
+      // which were never cleaned up by $disconnect expire
+      //
+      // the key schema of an existing index can't be changed and only
+      // one index can be added per deploy, so the TokenIdIndex is added
+      // first and the connections of the user are looked up by the legacy
+      // UserIdIndex meanwhile, UserIdCreatedIndex sorted by Created is added
+      // by the follow-up deploy with userIdIndexSorted switched on, see
+      // README, the legacy index can be removed in the deploy after
+      const userIdIndexSorted = false;
+      const legacyUserIdIndexName = 'UserIdIndex';
+      const userIdIndexName = userIdIndexSorted ? 'UserIdCreatedIndex' : legacyUserIdIndexName;
+      const tokenIdIndexName = 'TokenIdIndex';
+      const userIdIndexEnvironment = {
+        CONFIG_USER_ID_INDEX_NAME: userIdIndexName,
+        CONFIG_USER_ID_INDEX_SORTED: String(userIdIndexSorted),
+      };
       const connections = new Table(stack, "connections", {
         fields: {
           ConnectionId: "string",
           UserId: "string",
+          TokenId: "string",
+          Created: "number",
         },
         primaryIndex: { partitionKey: "ConnectionId" },
+        timeToLiveAttribute: "ExpiresAt",
+        stream: "new_and_old_images",
         globalIndexes: {
-          [userIdIndexName]: {
+          [legacyUserIdIndexName]: {
             partitionKey: "UserId",
             projection: "keys_only",
           },
+          ...(userIdIndexSorted ? {
+            UserIdCreatedIndex: {
+              partitionKey: "UserId",
+              sortKey: "Created",
+              projection: ["ExpiresAt", "Evicted"],
+            },
+          } : {}),
+          [tokenIdIndexName]: {
+            partitionKey: "TokenId",
+            projection: "keys_only",
+          },
+        },
+      });
+
+      // when the users were last seen, see pkg/presence
+      const presence = new Table(stack, "presence", {
+        fields: {
+          UserId: "string",
+        },
+        primaryIndex: { partitionKey: "UserId" },
+      });
+
+      // users following presence of other users, see pkg/follower
+      const followers = new Table(stack, "followers", {
+        fields: {
+          UserId: "string",
+          FollowerId: "string",
+        },
+        primaryIndex: { partitionKey: "UserId", sortKey: "FollowerId" },
+      });
+
+      // schedule presence checks when the connections of the users change,
+      // the checks are delayed so flapping connections don't spam followers
+      connections.addConsumers(stack, {
+        presence: {
+          function: {
+            timeout: 10,
+            handler: "cmd/presence/stream/main.go",
+            permissions: [presenceCheck],
+            environment: {
+              CONFIG_SQS_PRESENCE_CHECK_URL: presenceCheck.queueUrl,
+              CONFIG_PRESENCE_DEBOUNCE: "30",
+            },
+          },
+        },
+      });
+
+      // positions where the long running jobs stopped, see pkg/checkpoint
+      const checkpoints = new Table(stack, "checkpoints", {
+        fields: {
+          Name: "string",
+        },
+        primaryIndex: { partitionKey: "Name" },
+      });
+
+      // single-use connect tickets, see pkg/ticket
+      const tickets = new Table(stack, "tickets", {
+        fields: {
+          TicketId: "string",
+        },
+        primaryIndex: { partitionKey: "TicketId" },
+        timeToLiveAttribute: "ExpiresAt",
+      });
+
+      // verify the bearer token of the REST api
+      const httpAuthorizer = new Function(stack, "httpAuthorizer", {
+        timeout: 10,
+        handler: "cmd/http_authorizer/main.go",
+        permissions: [revocations],
+        environment: tokenEnvironment,
+      });
+
+      // REST api
+      const api = new Api(stack, "api", {
+
+        // verify the bearer token for the routes using the token authorizer,
+        // the results are cached briefly, so the token routes which mint
+        // new credentials check the revocations once more themselves
+        authorizers: {
+          token: {
+            type: "lambda",
+            resultsCacheTtl: "30 seconds",
+            function: httpAuthorizer,
+          },
+        },
+        routes: {
+          "POST /token": {
+            function: {
+              timeout: 10,
+              handler: "cmd/token/issuer/main.go",
+              permissions: [keys],
+              environment: {
+                ...tokenEnvironment,
+                CONFIG_KEYS_TABLE_ID: keys.tableName,
+                CONFIG_CREDENTIALS: process.env.CREDENTIALS ?? "{}",
+                CONFIG_TOKEN_DEFAULT_SCOPES: "ping",
+              },
+            }
+          },
+          "POST /token/ticket": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/token/ticket/main.go",
+              permissions: [tickets, revocations],
+              environment: {
+                CONFIG_TICKETS_TABLE_ID: tickets.tableName,
+                CONFIG_REVOCATIONS_TABLE_ID: revocations.tableName,
+              },
+            }
+          },
+          "POST /token/refresh": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/token/refresh/main.go",
+              permissions: [keys, revocations],
+              environment: {
+                ...tokenEnvironment,
+                CONFIG_KEYS_TABLE_ID: keys.tableName,
+              },
+            }
+          },
+          "POST /token/revoke": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/token/revoke/main.go",
+              permissions: [revocations, connections, deleteConnection],
+              environment: {
+                ...tokenEnvironment,
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                CONFIG_TOKEN_ID_INDEX_NAME: tokenIdIndexName,
+                CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
+              },
+            }
+          },
+          "GET /users/{id}/presence": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/presence/user/main.go",
+              permissions: [connections, presence],
+              environment: {
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                ...userIdIndexEnvironment,
+                CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+              },
+            }
+          },
+          "POST /users/presence": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/presence/batch/main.go",
+              permissions: [connections, presence],
+              environment: {
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                ...userIdIndexEnvironment,
+                CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+              },
+            }
+          },
+          "POST /users/{id}/followers": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/presence/follow/main.go",
+              permissions: [followers],
+              environment: {
+                CONFIG_FOLLOWERS_TABLE_ID: followers.tableName,
+              },
+            }
+          },
+          "DELETE /users/{id}/followers": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/presence/follow/main.go",
+              permissions: [followers],
+              environment: {
+                CONFIG_FOLLOWERS_TABLE_ID: followers.tableName,
+              },
+            }
+          },
+          "POST /users/{id}/logout": {
+            authorizer: "token",
+            function: {
+              timeout: 10,
+              handler: "cmd/logout/main.go",
+              permissions: [logoutUser],
+              environment: {
+                CONFIG_SQS_LOGOUT_USER_URL: logoutUser.queueUrl,
+              },
+            }
+          },
+          "GET /.well-known/jwks.json": {
+            function: {
+              timeout: 10,
+              handler: "cmd/token/jwks/main.go",
+              permissions: [keys],
+              environment: {
+                CONFIG_KEYS_TABLE_ID: keys.tableName,
+              },
+            }
+          },
         },
       });
 
+      // the verifiers load the public keys from the JWKS endpoint
+      const jwksUrl = api.url + "/.well-known/jwks.json";
+      httpAuthorizer.addEnvironment("CONFIG_JWKS_URL", jwksUrl);
+      api.getFunction("POST /token/revoke")?.addEnvironment("CONFIG_JWKS_URL", jwksUrl);
+
+      // authentication of websocket connections differs per stage, the web
+      // app in production connects only from its own origin using the session
+      // cookie, native apps use tickets or tokens, the anonymous connections
+      // have to send the authorize action afterwards
+      if (app.stage === "production" && !process.env.ALLOWED_ORIGINS) {
+        throw new Error("ALLOWED_ORIGINS has to be set in production, no browser can connect otherwise");
+      }
+      const connectAuthEnvironment = app.stage === "production" ? {
+        CONFIG_AUTH_METHODS: "ticket,token,cookie",
+        CONFIG_ALLOWED_ORIGINS: process.env.ALLOWED_ORIGINS ?? "",
+        CONFIG_SESSION_COOKIE: "session",
+        CONFIG_SESSION_SECRET: process.env.SESSION_SECRET ?? "",
+      } : {
+        CONFIG_AUTH_METHODS: "ticket,token,cookie,anonymous",
+        CONFIG_ALLOWED_ORIGINS: process.env.ALLOWED_ORIGINS ?? "http://localhost:3000",
+        CONFIG_SESSION_COOKIE: "session",
+        CONFIG_SESSION_SECRET: process.env.SESSION_SECRET ?? "",
+      };
+
+      // limit of connections per user, enforced at $connect and when
+      // the anonymous connection is authorized
+      const connectionLimitEnvironment = {
+        CONFIG_MAX_CONNECTIONS_PER_USER: "10",
+        CONFIG_CONNECTION_LIMIT_POLICY: "evict-oldest",
+      };
+
       // websocket api
       const wsApi = new WebSocketApi(stack, "wsapi", {
+
+        // verify the connect token before the connection is opened,
+        // the credentials are accepted from several places so no
+        // identity source is enforced by API Gateway
+        authorizer: {
+          type: "lambda",
+          identitySource: [],
+          function: new Function(stack, "authorizer", {
+            timeout: 10,
+            handler: "cmd/authorizer/main.go",
+            permissions: [revocations, tickets],
+            environment: {
+              ...tokenEnvironment,
+              CONFIG_JWKS_URL: jwksUrl,
+              CONFIG_TICKETS_TABLE_ID: tickets.tableName,
+
+              ...connectAuthEnvironment,
+            },
+          }),
+        },
         routes: {
 
           // execute when connection is opened
@@ -64,10 +373,14 @@ export default {
             function: {
               timeout: 10,
               handler: "cmd/connect/main.go",
-              permissions: [connections, deleteConnection],
+              permissions: [connections, presence, deleteConnection],
               environment: {
                 CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                CONFIG_PRESENCE_TABLE_ID: presence.tableName,
                 CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
+                CONFIG_ANONYMOUS_SESSION_TTL: "900",
+                ...userIdIndexEnvironment,
+                ...connectionLimitEnvironment,
               },
             }
           },
@@ -77,9 +390,10 @@ export default {
             function: {
               timeout: 10,
               handler: "cmd/disconnect/main.go",
-              permissions: [connections],
+              permissions: [connections, presence],
               environment: {
                 CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                CONFIG_PRESENCE_TABLE_ID: presence.tableName,
               },
             }
           },
@@ -89,20 +403,53 @@ export default {
             function: {
               timeout: 10,
               handler: "cmd/default/main.go",
-              permissions: [notifyConnection],
+              permissions: [notifyConnection, connections],
               environment: {
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
                 CONFIG_SQS_NOTIFY_CONNECTION_URL: notifyConnection.queueUrl,
               },
             }
           },
 
           // handle specific messages
+          authorize: {
+            function: {
+              timeout: 10,
+              handler: "cmd/authorize/main.go",
+              permissions: [notifyConnection, deleteConnection, connections, presence, revocations],
+              environment: {
+                ...tokenEnvironment,
+                CONFIG_JWKS_URL: jwksUrl,
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+                CONFIG_SQS_NOTIFY_CONNECTION_URL: notifyConnection.queueUrl,
+                CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
+                ...userIdIndexEnvironment,
+                ...connectionLimitEnvironment,
+              },
+            }
+          },
+          reauth: {
+            function: {
+              timeout: 10,
+              handler: "cmd/reauth/main.go",
+              permissions: [notifyConnection, deleteConnection, connections, revocations],
+              environment: {
+                ...tokenEnvironment,
+                CONFIG_JWKS_URL: jwksUrl,
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+                CONFIG_SQS_NOTIFY_CONNECTION_URL: notifyConnection.queueUrl,
+                CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
+              },
+            }
+          },
           ping: {
             function: {
               timeout: 10,
               handler: "cmd/ping/main.go",
-              permissions: [notifyConnection],
+              permissions: [notifyConnection, connections],
               environment: {
+                CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
                 CONFIG_SQS_NOTIFY_CONNECTION_URL: notifyConnection.queueUrl,
               },
             }
@@ -110,38 +457,145 @@ export default {
         },
       });
 
+      // admin routes need the websocket api to look up live connections
+      api.addRoutes(stack, {
+        "GET /connections/{id}": {
+          authorizer: "token",
+          function: {
+            timeout: 10,
+            handler: "cmd/inspect_connection/main.go",
+            permissions: [
+              connections,
+              new iam.PolicyStatement({
+                actions: ["execute-api:ManageConnections"],
+                effect: iam.Effect.ALLOW,
+                resources: [
+                  wsApi._connectionsArn.replace("/POST/*", "/*"),
+                ],
+              }),
+            ],
+            environment: {
+              CONFIG_API_GATEWAY_ENDPOINT: wsApi.url.replace("wss://", "https://"),
+              CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+            },
+          }
+        },
+      });
+
       // notify connection consumer      
       notifyConnection.addConsumer(stack, {
+        // report only the failed messages of the batch
+        cdk: {
+          eventSource: {
+            reportBatchItemFailures: true,
+          },
+        },
         function: {
           timeout: 10,
           handler: "cmd/notify_connection/main.go",
-          permissions: [wsApi],
+          permissions: [wsApi, connections, presence, notifyConnectionDeadLetter],
           environment: {
             CONFIG_API_GATEWAY_ENDPOINT: wsApi.url.replace("wss://", "https://"),
+            CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+            CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+            CONFIG_SQS_NOTIFY_CONNECTION_DLQ_URL: notifyConnectionDeadLetter.queueUrl,
           },
         }
       });
 
       // notify user queue consumer
       notifyUser.addConsumer(stack, {
+        // report only the failed messages of the batch
+        cdk: {
+          eventSource: {
+            reportBatchItemFailures: true,
+          },
+        },
         function: {
           timeout: 10,
           handler: "cmd/notify_user/main.go",
-          permissions: [notifyConnection, connections],
+          // the notification is sent back to the queue to continue
+          // where it stopped when the connections can't be loaded
+          permissions: [wsApi, notifyConnection, notifyConnectionDeadLetter, notifyUser, connections, presence],
           environment: {
+            CONFIG_API_GATEWAY_ENDPOINT: wsApi.url.replace("wss://", "https://"),
             CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+            CONFIG_PRESENCE_TABLE_ID: presence.tableName,
             CONFIG_SQS_NOTIFY_CONNECTION_URL: notifyConnection.queueUrl,
-            CONFIG_USER_ID_INDEX_NAME: userIdIndexName,
+            CONFIG_SQS_NOTIFY_CONNECTION_DLQ_URL: notifyConnectionDeadLetter.queueUrl,
+            CONFIG_SQS_NOTIFY_USER_URL: notifyUser.queueUrl,
+            ...userIdIndexEnvironment,
+
+            // "queue" sends every connection through the notify
+            // connection queue, "direct" posts into the connections
+            // and uses the queue only for the retryable failures
+            CONFIG_NOTIFY_MODE: "direct",
+            CONFIG_NOTIFY_CONCURRENCY: "10",
+          },
+        }
+      });
+
+      // presence check consumer, notifies the followers
+      presenceCheck.addConsumer(stack, {
+        // report only the failed messages of the batch
+        cdk: {
+          eventSource: {
+            reportBatchItemFailures: true,
+          },
+        },
+        function: {
+          timeout: 30,
+          handler: "cmd/presence/notify/main.go",
+          permissions: [connections, presence, followers, notifyUser],
+          environment: {
+            CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+            ...userIdIndexEnvironment,
+            CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+            CONFIG_FOLLOWERS_TABLE_ID: followers.tableName,
+            CONFIG_SQS_NOTIFY_USER_URL: notifyUser.queueUrl,
+          },
+        }
+      });
+
+      // logout user queue consumer, closes all connections of the user
+      logoutUser.addConsumer(stack, {
+        // report only the failed messages of the batch
+        cdk: {
+          eventSource: {
+            reportBatchItemFailures: true,
+          },
+        },
+        function: {
+          timeout: 30,
+          handler: "cmd/logout_user/main.go",
+          permissions: [connections, deleteConnection, revocations],
+          environment: {
+            ...tokenEnvironment,
+            CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+            ...userIdIndexEnvironment,
+            CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
           },
         }
       });
 
       // delete connection consumer
       deleteConnection.addConsumer(stack, {
+        // report only the failed messages of the batch
+        cdk: {
+          eventSource: {
+            reportBatchItemFailures: true,
+          },
+        },
         function: {
           timeout: 10,
           handler: "cmd/delete_connection/main.go",
           permissions: [
+            connections,
+            presence,
+
+            // the deletions scheduled too far in the
+            // future are sent back to the queue
+            deleteConnection,
 
             // attach custom policy since the default
             // _connectionsArn provided by wsApi
@@ -156,14 +610,67 @@ export default {
           ],
           environment: {
             CONFIG_API_GATEWAY_ENDPOINT: wsApi.url.replace("wss://", "https://"),
+            CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+            CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+            CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
           },
         }
       });
 
+      // close connections idle for too long
+      new Cron(stack, "reaper", {
+        schedule: "rate(5 minutes)",
+        job: {
+          function: {
+            timeout: 60,
+            handler: "cmd/reaper/main.go",
+            permissions: [connections, deleteConnection],
+            environment: {
+              CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+              CONFIG_SQS_DELETE_CONNECTION_URL: deleteConnection.queueUrl,
+              CONFIG_IDLE_TIMEOUT: "1800",
+            },
+          },
+        },
+      });
+
+      // delete records of the connections API Gateway no longer knows,
+      // the table is walked slowly so it doesn't eat the table capacity
+      new Cron(stack, "reconcile", {
+        schedule: "rate(1 hour)",
+        job: {
+          function: {
+            timeout: 900,
+            handler: "cmd/reconcile/main.go",
+            permissions: [
+              connections,
+              presence,
+              checkpoints,
+              new iam.PolicyStatement({
+                actions: ["execute-api:ManageConnections"],
+                effect: iam.Effect.ALLOW,
+                resources: [
+                  wsApi._connectionsArn.replace("/POST/*", "/*"),
+                ],
+              }),
+            ],
+            environment: {
+              CONFIG_API_GATEWAY_ENDPOINT: wsApi.url.replace("wss://", "https://"),
+              CONFIG_CONNECTIONS_TABLE_ID: connections.tableName,
+              CONFIG_PRESENCE_TABLE_ID: presence.tableName,
+              CONFIG_CHECKPOINTS_TABLE_ID: checkpoints.tableName,
+              CONFIG_RECONCILE_RATE: "20",
+              CONFIG_RECONCILE_DRY_RUN: "false",
+            },
+          },
+        },
+      });
+
       // set console outputs
       stack.addOutputs({
         ApiEndpoint: api.url,
         WsApiEndpoint: wsApi.url,
+        KeysTable: keys.tableName,
       });
 
     });